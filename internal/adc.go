@@ -0,0 +1,323 @@
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	googleTokenURL   = "https://oauth2.googleapis.com/token"
+	gceMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	tokenTimeout     = 15 * time.Second
+	// Token requests failing with network errors or 5xx responses are retried with
+	// jittered exponential backoff
+	tokenRetries      = 3
+	tokenRetryBackoff = 250 * time.Millisecond
+	// tokenRefreshMargin renews access tokens this long before they expire
+	tokenRefreshMargin = time.Minute
+)
+
+// adcTokenSource mints OAuth access tokens from Google Application Default Credentials:
+// gemini_credentials_file, the file named by GOOGLE_APPLICATION_CREDENTIALS, the gcloud
+// well-known file, or the GCE/Cloud Run metadata server. User credentials are refreshed
+// with their refresh token and service account keys through the JWT bearer flow.
+// Tokens are cached until shortly before they expire.
+type adcTokenSource struct {
+	client       *http.Client
+	source       string // where the credentials came from, for logs
+	id           string // stable identifier of the credentials
+	quotaProject string
+
+	// authorized_user credentials
+	clientID     string
+	clientSecret string
+	refreshToken string
+
+	// service_account credentials
+	saEmail    string
+	saKeyID    string
+	saKey      *rsa.PrivateKey
+	saTokenURI string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newADCTokenSource locates Application Default Credentials, preferring credentialsJSON
+// and then credentialsFile when set
+func newADCTokenSource(credentialsFile, credentialsJSON string, transport http.RoundTripper) (*adcTokenSource, error) {
+	ts := &adcTokenSource{client: &http.Client{Timeout: tokenTimeout, Transport: transport}}
+	if credentialsJSON != "" {
+		if err := ts.parseCredentials("gemini_credentials_json", []byte(credentialsJSON)); err != nil {
+			return nil, err
+		}
+		return ts, nil
+	}
+
+	path := credentialsFile
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		if wellKnown := adcWellKnownFile(); wellKnown != "" {
+			if _, err := os.Stat(wellKnown); err == nil {
+				path = wellKnown
+			}
+		}
+	}
+	if path == "" {
+		// Fall back to the metadata server of the Google Cloud runtime
+		ts.source = "metadata server"
+		ts.id = "adc-metadata"
+		return ts, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read application default credentials: %w", err)
+	}
+	if err := ts.parseCredentials(path, data); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// parseCredentials loads authorized_user or service_account credentials read from source
+func (ts *adcTokenSource) parseCredentials(source string, data []byte) error {
+	ts.source = source
+	ts.quotaProject = gjson.GetBytes(data, "quota_project_id").String()
+
+	switch credType := gjson.GetBytes(data, "type").String(); credType {
+	case "authorized_user":
+		ts.clientID = gjson.GetBytes(data, "client_id").String()
+		ts.clientSecret = gjson.GetBytes(data, "client_secret").String()
+		ts.refreshToken = gjson.GetBytes(data, "refresh_token").String()
+		if ts.clientID == "" || ts.refreshToken == "" {
+			return fmt.Errorf("%s: authorized_user credentials need client_id and refresh_token", source)
+		}
+		ts.id = "adc-" + sha256Hex([]byte(ts.clientID + ts.refreshToken))[:8]
+	case "service_account":
+		ts.saEmail = gjson.GetBytes(data, "client_email").String()
+		ts.saKeyID = gjson.GetBytes(data, "private_key_id").String()
+		ts.saTokenURI = gjson.GetBytes(data, "token_uri").String()
+		if ts.saTokenURI == "" {
+			ts.saTokenURI = googleTokenURL
+		}
+		if ts.saEmail == "" {
+			return fmt.Errorf("%s: service_account credentials need client_email", source)
+		}
+		var err error
+		if ts.saKey, err = parseServiceAccountKey(gjson.GetBytes(data, "private_key").String()); err != nil {
+			return fmt.Errorf("%s: invalid private_key: %w", source, err)
+		}
+		ts.id = "sa-" + sha256Hex([]byte(ts.saEmail + ts.saKeyID))[:8]
+	default:
+		return fmt.Errorf("%s: unsupported credentials type %q", source, credType)
+	}
+	return nil
+}
+
+// adcWellKnownFile returns the path where gcloud auth application-default login stores credentials
+func adcWellKnownFile() string {
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "gcloud", "application_default_credentials.json")
+		}
+		return ""
+	}
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// ID returns a short, non-reversible identifier of the credentials
+func (ts *adcTokenSource) ID() string {
+	return ts.id
+}
+
+// Token returns a valid access token, refreshing it when it is about to expire
+func (ts *adcTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Until(ts.expires) > tokenRefreshMargin {
+		return ts.token, nil
+	}
+
+	defer trackPhase(ctx, phaseTokenRefresh)()
+	var req *http.Request
+	var err error
+	switch {
+	case ts.saKey != nil:
+		var assertion string
+		assertion, err = signServiceAccountJWT(ts.saKey, ts.saKeyID, ts.saEmail, ts.saTokenURI, time.Now())
+		if err != nil {
+			return "", &AuthError{Err: fmt.Errorf("failed to sign service account assertion: %w", err)}
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, ts.saTokenURI, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	case ts.refreshToken != "":
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {ts.clientID},
+			"client_secret": {ts.clientSecret},
+			"refresh_token": {ts.refreshToken},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	default:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gceMetadataToken, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token, expiresIn, err := ts.exchangeWithRetries(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	ts.expires = time.Now().Add(expiresIn)
+	return ts.token, nil
+}
+
+// exchangeWithRetries performs a token request, retrying network errors and 5xx responses
+func (ts *adcTokenSource) exchangeWithRetries(ctx context.Context, req *http.Request) (string, time.Duration, error) {
+	delay := tokenRetryBackoff
+	for attempt := 0; ; attempt++ {
+		token, expiresIn, err := ts.exchange(ctx, req)
+		var transientErr *TransientError
+		if !errors.As(err, &transientErr) || attempt >= tokenRetries {
+			return token, expiresIn, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return token, expiresIn, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return token, expiresIn, err
+			}
+			req.Body = body
+		}
+
+		// Jitter in [delay/2, 3*delay/2) keeps concurrent refreshes from synchronizing
+		wait := delay/2 + rand.N(delay)
+		slog.Warn("Token request failed, retrying", "host", req.URL.Host, "delay", wait, "attempt", attempt+1,
+			"retries", tokenRetries, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", 0, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// exchange performs a token request and parses the access token response
+func (ts *adcTokenSource) exchange(ctx context.Context, req *http.Request) (string, time.Duration, error) {
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", 0, classifyGeminiTransportError(ctx, fmt.Errorf("token request to %s failed: %w", req.URL.Host, err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, &TransientError{Err: fmt.Errorf("failed to read token response: %w", err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if gjson.GetBytes(body, "error").String() == "invalid_grant" {
+			return "", 0, &AuthError{Err: &revokedCredentialsError{
+				Source:      ts.source,
+				Account:     ts.account(),
+				Description: gjson.GetBytes(body, "error_description").String(),
+			}}
+		}
+		err := fmt.Errorf("token request to %s returned status %d: %s", req.URL.Host, resp.StatusCode,
+			gjson.GetBytes(body, "error").String())
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", 0, &TransientError{Err: err}
+		}
+		return "", 0, &AuthError{Err: err}
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.AccessToken == "" {
+		return "", 0, &AuthError{Err: errors.New("token response has no access_token")}
+	}
+	return tok.AccessToken, time.Duration(tok.ExpiresIn) * time.Second, nil
+}
+
+// account describes whose credentials these are, for messages
+func (ts *adcTokenSource) account() string {
+	if ts.saEmail != "" {
+		return ts.saEmail
+	}
+	return ts.id
+}
+
+// revokedCredentialsError reports an invalid_grant token response: the refresh token was
+// revoked or expired, or the service account key was deleted or disabled
+type revokedCredentialsError struct {
+	Source      string
+	Account     string
+	Description string
+}
+
+func (e *revokedCredentialsError) Error() string {
+	return fmt.Sprintf("credentials %s (%s) were revoked or have expired (invalid_grant: %s)",
+		e.Source, e.Account, e.Description)
+}
+
+// Hint tells the operator how to replace the credentials
+func (e *revokedCredentialsError) Hint() string {
+	if strings.Contains(e.Account, "@") {
+		return "create a new key for the service account and update gemini_credentials_file"
+	}
+	return `run "gcloud auth application-default login" again, or update gemini_credentials_file`
+}
+
+// applyHeaders adds the quota project header required when user credentials call Google APIs
+func (ts *adcTokenSource) applyHeaders(h http.Header) {
+	if ts.quotaProject != "" {
+		h.Set("x-goog-user-project", ts.quotaProject)
+	}
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// adminPathPrefix is the namespace of the operational admin API
+const adminPathPrefix = "/admin"
+
+// isAdminRequest checks if the path targets the admin API
+func isAdminRequest(path string) bool {
+	return path == adminPathPrefix || strings.HasPrefix(path, adminPathPrefix+"/")
+}
+
+// adminAuthorized reports whether the request presents the admin token via
+// x-admin-token or Authorization: Bearer
+func (p *Proxy) adminAuthorized(r *http.Request) bool {
+	presented := clientAPIKeys(r)
+	if v := r.Header.Get("x-admin-token"); v != "" {
+		presented = append(presented, v)
+	}
+	for _, token := range presented {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.cfg.AdminToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdmin serves the admin API. It is protected by admin_token instead of the
+// client proxy_api_keys and is disabled when no admin token is configured.
+func (p *Proxy) handleAdmin(w http.ResponseWriter, r *http.Request, path string) {
+	if p.cfg.AdminToken == "" {
+		writeError(w, http.StatusNotFound, errTypeNotFound, "Admin API is disabled (admin_token is not set)")
+		return
+	}
+	// The dashboard page carries no data and prompts for the token itself
+	if r.Method == http.MethodGet && path == "/admin/dashboard" {
+		p.handleDashboard(w, r)
+		return
+	}
+	if !p.adminAuthorized(r) {
+		slog.Warn("Rejected unauthenticated admin request", "remote_addr", r.RemoteAddr, "method", r.Method, "path", path)
+		p.metrics.Inc("admin.unauthorized")
+		writeError(w, http.StatusUnauthorized, errTypeAuthentication, "Invalid or missing admin token")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "/admin/events":
+		p.handleAdminEvents(w, r)
+	case r.Method == http.MethodGet && path == "/admin/auth":
+		p.writeAdminJSON(w, r, map[string]interface{}{"gemini": p.adminAuthEntries()})
+	case r.Method == http.MethodPost && path == "/admin/auth/reload":
+		p.handleAdminAuthReload(w, r)
+	case r.Method == http.MethodPost && path == "/admin/auth/reset":
+		p.geminiClient.clearCooldown()
+		slog.Info("Admin cleared the Gemini key cooldowns")
+		p.writeAdminJSON(w, r, map[string]interface{}{"gemini": p.adminAuthEntries()})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/admin/auth/"):
+		p.handleAdminKeyAction(w, r, strings.TrimPrefix(path, "/admin/auth/"))
+	case r.Method == http.MethodPost && path == "/admin/cache/flush":
+		flushed := p.urlResolver.Flush()
+		slog.Info("Admin flushed caches", "resolved_urls", flushed)
+		p.writeAdminJSON(w, r, map[string]interface{}{"flushed": map[string]int{"resolved_urls": flushed}})
+	case r.Method == http.MethodPost && path == "/admin/search-test":
+		p.handleAdminSearchTest(w, r)
+	case r.Method == http.MethodGet && path == "/admin/dashboard/data":
+		p.handleDashboardData(w, r)
+	case r.Method == http.MethodGet && path == "/admin/counters":
+		p.writeAdminJSON(w, r, p.metrics.Snapshot())
+	default:
+		writeError(w, http.StatusNotFound, errTypeNotFound, "Unknown admin endpoint")
+	}
+}
+
+// adminAuthEntries lists the Gemini credentials in rotation order, identified only by key
+// hash, with the remaining cooldown
+func (p *Proxy) adminAuthEntries() []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, k := range p.geminiClient.keys.status() {
+		entries = append(entries, map[string]interface{}{
+			"key":              k.ID,
+			"type":             p.cfg.GeminiAuth,
+			"model":            p.cfg.WebSearchModel,
+			"base_url":         p.cfg.GeminiAPIBaseURL,
+			"current":          k.Current,
+			"cooldown_seconds": int64(k.Cooldown.Seconds()),
+			"in_flight":        k.InFlight,
+			"labels":           k.Labels,
+			"disabled":         k.Disabled,
+			"error_rate":       k.ErrorRate,
+			"requests_today":   p.usage.KeyRequests(k.ID),
+			"daily_limit":      p.keyDailyLimit(k.ID),
+		})
+	}
+	return entries
+}
+
+// handleAdminKeyAction serves POST /admin/auth/{key_id}/{disable|enable|activate}:
+// taking a Gemini key out of the rotation, putting it back with its failure state
+// cleared, or making it the current key
+func (p *Proxy) handleAdminKeyAction(w http.ResponseWriter, r *http.Request, rest string) {
+	id, action, _ := strings.Cut(rest, "/")
+	var act func(string) bool
+	switch action {
+	case "disable":
+		act = p.geminiClient.keys.disableKey
+	case "enable":
+		act = p.geminiClient.keys.enableKey
+	case "activate":
+		act = p.geminiClient.keys.activateKey
+	default:
+		writeError(w, http.StatusNotFound, errTypeNotFound, "Unknown admin endpoint")
+		return
+	}
+	if !act(id) {
+		writeError(w, http.StatusNotFound, errTypeNotFound, "No Gemini key "+id)
+		return
+	}
+	slog.Info("Admin changed a Gemini key", "key", id, "action", action)
+	p.writeAdminJSON(w, r, map[string]interface{}{"gemini": p.adminAuthEntries()})
+}
+
+// handleAdminAuthReload reloads the configuration, like SIGHUP
+func (p *Proxy) handleAdminAuthReload(w http.ResponseWriter, r *http.Request) {
+	if err := p.Reload(); err != nil {
+		slog.Error("Admin reload failed", "path", p.cfg.path, "error", err)
+		writeError(w, http.StatusUnprocessableEntity, errTypeInvalidRequest, "Reload failed: "+err.Error())
+		return
+	}
+	p.writeAdminJSON(w, r, map[string]interface{}{"gemini": p.adminAuthEntries()})
+}
+
+// writeAdminJSON writes an admin API response
+func (p *Proxy) writeAdminJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	resp, _ := json.Marshal(v)
+	p.writeJSON(w, r, http.StatusOK, resp)
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const alertTimeout = 10 * time.Second
+
+// Alert kinds; each kind is rate-limited separately
+const (
+	alertQuotaExhausted = "quota_exhausted"
+	alertAuthFailed     = "auth_failed"
+)
+
+// Alerter posts Slack-compatible {"text": ...} messages to a webhook, sending each
+// kind of alert at most once per cooldown period. A nil Alerter sends nothing.
+type Alerter struct {
+	url      string
+	client   *http.Client
+	cooldown time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewAlerter creates an alerter for the given webhook URL
+func NewAlerter(url string, cooldown time.Duration, transport http.RoundTripper) *Alerter {
+	return &Alerter{
+		url:      url,
+		client:   &http.Client{Timeout: alertTimeout, Transport: transport},
+		cooldown: cooldown,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// Fire sends an alert in the background unless the same kind was sent within the cooldown
+func (a *Alerter) Fire(kind, text string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if last, ok := a.last[kind]; ok && time.Since(last) < a.cooldown {
+		a.mu.Unlock()
+		return
+	}
+	a.last[kind] = time.Now()
+	a.mu.Unlock()
+
+	go a.send(kind, text)
+}
+
+// send posts a single alert to the webhook
+func (a *Alerter) send(kind, text string) {
+	body, _ := json.Marshal(map[string]string{"text": "cpa_websearch_proxy: " + text})
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to build alert request", "kind", kind, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to send alert", "kind", kind, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Error("Alert webhook rejected alert", "kind", kind, "status", resp.StatusCode)
+		return
+	}
+	slog.Info("Alert sent", "kind", kind)
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit outcomes
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeError   = "error"
+)
+
+// auditEntry is one line of the search audit log. Queries are recorded only as hashes.
+type auditEntry struct {
+	Time        string `json:"time"`
+	QuerySHA256 string `json:"query_sha256"`
+	Backend     string `json:"backend"`
+	Key         string `json:"key"`
+	Model       string `json:"model,omitempty"`
+	Results     int    `json:"results"`
+	Outcome     string `json:"outcome"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+// AuditLog is an append-only JSON Lines sink recording every web search, separate
+// from the normal log. A nil AuditLog records nothing.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewAuditLog opens (or creates) the audit log file in append mode
+func NewAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: f}, nil
+}
+
+// Record appends an entry to the audit log
+func (a *AuditLog) Record(entry auditEntry) {
+	if a == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(line); err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+}
+
+// recentSearchLimit is the number of searches kept for the dashboard
+const recentSearchLimit = 50
+
+// searchHistory keeps the most recent audit entries in memory
+type searchHistory struct {
+	mu      sync.Mutex
+	entries []auditEntry
+}
+
+// add records an entry, dropping the oldest beyond recentSearchLimit
+func (h *searchHistory) add(entry auditEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == recentSearchLimit {
+		h.entries = append(h.entries[:0], h.entries[1:]...)
+	}
+	h.entries = append(h.entries, entry)
+}
+
+// recent returns the recorded entries, newest first
+func (h *searchHistory) recent() []auditEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]auditEntry, len(h.entries))
+	for i, entry := range h.entries {
+		out[len(out)-1-i] = entry
+	}
+	return out
+}
+
+// auditSearch records the outcome of a web search for the given Claude payload in the
+// recent search history and the audit log
+func (p *Proxy) auditSearch(backend SearchBackend, claudePayload, geminiResp []byte, err error, start time.Time) {
+	entry := auditEntry{
+		Time:        start.UTC().Format(time.RFC3339Nano),
+		QuerySHA256: sha256Hex([]byte(ExtractUserQuery(claudePayload))),
+		Backend:     backend.Name(),
+		Key:         backend.KeyID(),
+		Model:       GetModel(claudePayload),
+		Outcome:     auditOutcomeSuccess,
+		DurationMS:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Outcome = auditOutcomeError
+		// Errors may quote request URLs carrying credentials, and the entry is served on
+		// the dashboard as well as written to the audit log
+		entry.Error = redactURLSecrets(err.Error())
+	} else {
+		entry.Results = len(extractGroundingMetadata(geminiResp).Get("groundingChunks").Array())
+	}
+	p.history.add(entry)
+	p.audit.Record(entry)
+}
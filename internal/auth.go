@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientAPIKeys returns the credentials presented by a client via x-api-key or
+// Authorization: Bearer
+func clientAPIKeys(r *http.Request) []string {
+	var keys []string
+	if v := r.Header.Get("x-api-key"); v != "" {
+		keys = append(keys, v)
+	}
+	if v := r.Header.Get("Authorization"); len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+		keys = append(keys, strings.TrimSpace(v[7:]))
+	}
+	return keys
+}
+
+// authorized reports whether the request presents one of the configured proxy API keys.
+// With no proxy_api_keys configured every request is allowed.
+func (p *Proxy) authorized(r *http.Request) bool {
+	keys := *p.clientKeys.Load()
+	if len(keys) == 0 {
+		return true
+	}
+	for _, presented := range clientAPIKeys(r) {
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(key)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientKeyHash identifies the client a request comes from by the SHA-256 of its
+// credential: the proxy API key it presented or, without proxy_api_keys, the credential
+// passed on to the upstream. It is "" for requests without a credential.
+func (p *Proxy) clientKeyHash(r *http.Request) string {
+	presented := clientAPIKeys(r)
+	if keys := *p.clientKeys.Load(); len(keys) > 0 {
+		var matched []string
+		for _, k := range presented {
+			for _, key := range keys {
+				if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+					matched = append(matched, k)
+				}
+			}
+		}
+		presented = matched
+	}
+	if len(presented) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(presented[0]))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAllowedCIDRs parses allowlist entries; a bare IP address allows just that host
+func parseAllowedCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowed_cidrs entry %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed_cidrs entry %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// sourceAllowed reports whether the request's source address is in the allowlist.
+// With no allowlist, and for Unix socket connections (no IP), every request is allowed.
+func (p *Proxy) sourceAllowed(r *http.Request) bool {
+	if len(p.allowedNets) == 0 {
+		return true
+	}
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.allowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
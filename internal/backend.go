@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// SearchBackendGemini is the built-in backend searching with Gemini's googleSearch tool
+const SearchBackendGemini = "gemini"
+
+// SearchBackend runs the web search of a Claude payload. Responses are in Gemini's
+// generateContent format, which the converters turn into Claude responses; engines that
+// return plain results build it with SearchResults.geminiResponse.
+type SearchBackend interface {
+	// Name returns the name the backend is registered under
+	Name() string
+	// KeyID returns a short, non-reversible identifier of the credential in use, for audit records
+	KeyID() string
+	// ExecuteSearch searches the web for the conversation in claudePayload
+	ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error)
+}
+
+// SearchBackendFactory creates a search backend from the configuration, sending its
+// requests through transport
+type SearchBackendFactory func(cfg *Config, transport http.RoundTripper) (SearchBackend, error)
+
+// searchBackends holds the registered backends besides gemini, by name
+var searchBackends = map[string]SearchBackendFactory{}
+
+// RegisterSearchBackend makes a search backend available as search_backend: name
+func RegisterSearchBackend(name string, factory SearchBackendFactory) {
+	if name == SearchBackendGemini || searchBackends[name] != nil {
+		panic("search backend " + name + " is already registered")
+	}
+	searchBackends[name] = factory
+}
+
+// searchBackendNames returns the names of every available backend, gemini first
+func searchBackendNames() []string {
+	names := make([]string, 0, len(searchBackends))
+	for name := range searchBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{SearchBackendGemini}, names...)
+}
+
+// isSearchBackend reports whether name is an available backend
+func isSearchBackend(name string) bool {
+	return name == SearchBackendGemini || searchBackends[name] != nil
+}
+
+// newSearchBackend creates the backend selected by search_backend, chained with
+// search_fallback_backends when there are any
+func newSearchBackend(cfg *Config, transport http.RoundTripper, gc *GeminiClient) (SearchBackend, error) {
+	primary, err := createSearchBackend(cfg.SearchBackend, cfg, transport, gc)
+	if err != nil || len(cfg.SearchFallbackBackends) == 0 {
+		return primary, err
+	}
+	backends := []SearchBackend{primary}
+	for _, name := range cfg.SearchFallbackBackends {
+		b, err := createSearchBackend(name, cfg, transport, gc)
+		if err != nil {
+			return nil, fmt.Errorf("search_fallback_backends: %w", err)
+		}
+		backends = append(backends, b)
+	}
+	return newBackendChain(backends, time.Duration(cfg.SearchBackendCooldown)*time.Second), nil
+}
+
+// createSearchBackend creates the backend registered under name. The Gemini client is
+// created by the proxy for its key pool and admin endpoints, and reused as the gemini backend.
+func createSearchBackend(name string, cfg *Config, transport http.RoundTripper, gc *GeminiClient) (SearchBackend, error) {
+	if name == SearchBackendGemini {
+		return gc, nil
+	}
+	factory := searchBackends[name]
+	if factory == nil {
+		return nil, fmt.Errorf("unknown search backend %q", name)
+	}
+	return factory(cfg, transport)
+}
+
+// SearchResults are the results of a search engine returning a list of pages, and the
+// answer it wrote when it has one
+type SearchResults struct {
+	Query   string
+	Answer  string
+	Results []SearchResult
+}
+
+// searchQuery returns the query a search engine should run for a Claude payload: the first
+// rewritten query or the last user message, with allowed_domains / blocked_domains as
+// site: operators
+func searchQuery(ctx context.Context, claudePayload []byte) (string, error) {
+	query := userQuery(ctx, claudePayload)
+	if query == "" {
+		return "", fmt.Errorf("no messages found in payload")
+	}
+	if filter := ExtractDomainFilter(claudePayload); filter != nil {
+		query += " " + filter.Operators()
+	}
+	return query, nil
+}
+
+// geminiResponse renders the results as a Gemini generateContent response: the answer
+// followed by a numbered digest of the results as the text, and the results as grounding
+// chunks. Chunks carry the snippet and page age too, which the converters pass on in the
+// web_search_result blocks.
+func (sr *SearchResults) geminiResponse() []byte {
+	var b strings.Builder
+	if sr.Answer != "" {
+		b.WriteString(sr.Answer + "\n\n")
+	}
+	for i, r := range sr.Results {
+		fmt.Fprintf(&b, "%d. %s (%s)\n", i+1, r.Title, r.URL)
+		if r.Snippet != "" {
+			fmt.Fprintf(&b, "   %s\n", r.Snippet)
+		}
+	}
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		text = "No results found."
+	}
+
+	resp := `{"candidates":[{"content":{"role":"model","parts":[]},"finishReason":"STOP","groundingMetadata":{"webSearchQueries":[],"groundingChunks":[]}}]}`
+	resp, _ = sjson.Set(resp, "candidates.0.content.parts.0.text", text)
+	if sr.Query != "" {
+		resp, _ = sjson.Set(resp, "candidates.0.groundingMetadata.webSearchQueries.0", sr.Query)
+	}
+	for _, r := range sr.Results {
+		if r.URL == "" {
+			continue
+		}
+		web := map[string]string{"uri": r.URL, "title": r.Title}
+		if r.Snippet != "" {
+			web["snippet"] = r.Snippet
+		}
+		if r.PageAge != "" {
+			web["pageAge"] = r.PageAge
+		}
+		resp, _ = sjson.Set(resp, "candidates.0.groundingMetadata.groundingChunks.-1",
+			map[string]map[string]string{"web": web})
+	}
+	return []byte(resp)
+}
+
+// doEngineRequest sends a request to the API of a search engine and returns the response
+// body, or an error with the engine's message when it doesn't succeed
+func doEngineRequest(ctx context.Context, client *http.Client, engine string, req *http.Request) ([]byte, error) {
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error quotes the request URL, which carries the API key of some engines
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURLSecrets(urlErr.URL)
+		}
+		return nil, fmt.Errorf("%s request failed: %w", engine, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", engine, err)
+	}
+	debugRecordFrom(ctx).write(engine+"_response.json", body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message := gjson.GetBytes(body, "error.message").String()
+		if message == "" {
+			message = gjson.GetBytes(body, "message").String()
+		}
+		if message == "" {
+			message = gjson.GetBytes(body, "error").String()
+		}
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s returned status %d: %s", engine, resp.StatusCode, message)
+	}
+	return body, nil
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backendChain tries search backends in order: when one fails or is rate limited it cools
+// down and the search moves on to the next. Backends cooling down are still tried, soonest
+// available first, once every other backend failed, so a search only fails when all do.
+type backendChain struct {
+	backends []SearchBackend
+	cooldown time.Duration // how long a failed backend is skipped, unless it says otherwise
+
+	mu        sync.Mutex
+	coolUntil map[string]time.Time
+
+	// onFailure, when set, is called with every backend failure that moves the search on
+	onFailure func(backend string, err error)
+}
+
+// newBackendChain creates a chain of backends, tried in the given order
+func newBackendChain(backends []SearchBackend, cooldown time.Duration) *backendChain {
+	return &backendChain{backends: backends, cooldown: cooldown, coolUntil: make(map[string]time.Time)}
+}
+
+// Name returns the name of the first backend of the chain
+func (bc *backendChain) Name() string {
+	return bc.backends[0].Name()
+}
+
+// KeyID returns the key of the first backend not cooling down
+func (bc *backendChain) KeyID() string {
+	return bc.order()[0].KeyID()
+}
+
+// ExecuteSearch runs the search with the first backend that succeeds
+func (bc *backendChain) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	var err error
+	backends := bc.order()
+	for i, b := range backends {
+		var resp []byte
+		if resp, err = b.ExecuteSearch(ctx, claudePayload); err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		bc.coolDown(b, err)
+		if bc.onFailure != nil {
+			bc.onFailure(b.Name(), err)
+		}
+		if i+1 < len(backends) {
+			slog.Warn("Search backend failed, trying the next one", "backend", b.Name(),
+				"next", backends[i+1].Name(), "error", err)
+		}
+	}
+	return nil, err
+}
+
+// order returns the backends available now in chain order, followed by the ones cooling
+// down by the end of their cooldown
+func (bc *backendChain) order() []SearchBackend {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	now := time.Now()
+	var available, cooling []SearchBackend
+	for _, b := range bc.backends {
+		if bc.coolUntil[b.Name()].After(now) {
+			cooling = append(cooling, b)
+		} else {
+			available = append(available, b)
+		}
+	}
+	sort.SliceStable(cooling, func(i, j int) bool {
+		return bc.coolUntil[cooling[i].Name()].Before(bc.coolUntil[cooling[j].Name()])
+	})
+	return append(available, cooling...)
+}
+
+// coolDown skips b for the chain's cooldown after err, or as long as a quota error asks
+func (bc *backendChain) coolDown(b SearchBackend, err error) {
+	d := bc.cooldown
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) && quotaErr.RetryAfter > d {
+		d = quotaErr.RetryAfter
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.coolUntil[b.Name()] = time.Now().Add(d)
+}
+
+// backendStatus describes a backend of the chain for the status endpoint
+type backendStatus struct {
+	Name     string  `json:"name"`
+	Key      string  `json:"key"`
+	Cooldown float64 `json:"cooldown_seconds"`
+}
+
+// status lists the backends of the chain in chain order
+func (bc *backendChain) status() []backendStatus {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	statuses := make([]backendStatus, 0, len(bc.backends))
+	for _, b := range bc.backends {
+		statuses = append(statuses, backendStatus{
+			Name:     b.Name(),
+			Key:      b.KeyID(),
+			Cooldown: max(time.Until(bc.coolUntil[b.Name()]), 0).Seconds(),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+)
+
+// searchBackendHeader selects the search backend of a request, e.g. "serper"
+const searchBackendHeader = "x-websearch-backend"
+
+type searchBackendKey struct{}
+
+// withSearchBackend makes searches under ctx use the backend registered under name
+func withSearchBackend(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, searchBackendKey{}, name)
+}
+
+// searchBackendFrom returns the backend name attached to ctx, or "" for the configured one
+func searchBackendFrom(ctx context.Context) string {
+	name, _ := ctx.Value(searchBackendKey{}).(string)
+	return name
+}
+
+// requestedBackend returns the search backend a request asks for: the one configured for
+// its proxy API key in proxy_api_key_backends, otherwise the x-websearch-backend header
+func (p *Proxy) requestedBackend(r *http.Request) string {
+	backends := *p.keyBackends.Load()
+	for _, presented := range clientAPIKeys(r) {
+		if name, ok := backends[presented]; ok {
+			return name
+		}
+	}
+	return r.Header.Get(searchBackendHeader)
+}
+
+// searchBackend returns the backend for searches under ctx: the one selected for the
+// request, created on first use, or the configured backend and its fallbacks
+func (p *Proxy) searchBackend(ctx context.Context) (SearchBackend, error) {
+	name := searchBackendFrom(ctx)
+	if name == "" || name == p.backend.Name() {
+		return p.backend, nil
+	}
+
+	p.backendsMu.Lock()
+	defer p.backendsMu.Unlock()
+	if b, ok := p.backends[name]; ok {
+		return b, nil
+	}
+	b, err := createSearchBackend(name, p.cfg, p.transport, p.geminiClient)
+	if err != nil {
+		return nil, err
+	}
+	p.backends[name] = b
+	return b, nil
+}
+
+// backendName returns the name of the backend searches under ctx go to, for logs
+func (p *Proxy) backendName(ctx context.Context) string {
+	if name := searchBackendFrom(ctx); name != "" {
+		return name
+	}
+	return p.backend.Name()
+}
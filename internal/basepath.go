@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// requestPrefix returns the external path prefix the proxy is served under: the
+// X-Forwarded-Prefix header set by a fronting reverse proxy, or the configured base_path
+func (p *Proxy) requestPrefix(r *http.Request) string {
+	if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" {
+		return "/" + strings.Trim(prefix, "/")
+	}
+	return p.basePath
+}
+
+// stripPrefix removes the external path prefix from the request URL so routing and
+// upstream forwarding see the same paths as a proxy served at the root
+func (p *Proxy) stripPrefix(r *http.Request) {
+	prefix := p.requestPrefix(r)
+	if prefix == "" || prefix == "/" {
+		return
+	}
+	if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+		r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		r.URL.RawPath = ""
+	}
+}
+
+// externalURL builds an absolute URL for a proxy path as seen by the client
+func (p *Proxy) externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	prefix := p.requestPrefix(r)
+	if prefix == "/" {
+		prefix = ""
+	}
+	return scheme + "://" + r.Host + prefix + path
+}
@@ -0,0 +1,504 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	batchesPathSuffix  = "/messages/batches"
+	batchExpiry        = 24 * time.Hour
+	batchSweepInterval = 10 * time.Minute
+
+	// Page size of batch lists by default and at most, as in the Messages API
+	defaultBatchListLimit = 20
+	maxBatchListLimit     = 1000
+)
+
+// localBatch tracks a message batch whose web_search items are processed by the proxy.
+// Items without web_search are submitted to the upstream as a separate batch.
+type localBatch struct {
+	mu sync.Mutex
+
+	id         string
+	upstreamID string
+	owner      string // clientKeyHash of the client that created the batch
+	createdAt  time.Time
+	endedAt    time.Time
+	canceledAt time.Time
+	cancel     context.CancelFunc
+
+	total     int
+	succeeded int
+	errored   int
+	canceled  int
+	results   []string // JSONL result lines for locally processed items
+}
+
+// batchStore holds the local batches created by this proxy instance
+type batchStore struct {
+	batches sync.Map // map[string]*localBatch
+}
+
+// newBatchStore creates the batch store and starts evicting expired batches
+func newBatchStore() *batchStore {
+	s := &batchStore{}
+	go s.run(batchSweepInterval)
+	return s
+}
+
+// run evicts expired batches on every tick
+func (s *batchStore) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sweep(time.Now())
+	}
+}
+
+// sweep drops the batches created more than batchExpiry before now, stopping the
+// processing of those that haven't ended
+func (s *batchStore) sweep(now time.Time) {
+	s.batches.Range(func(key, value interface{}) bool {
+		if b := value.(*localBatch); now.Sub(b.createdAt) > batchExpiry {
+			b.cancel()
+			s.batches.Delete(key)
+			slog.Debug("Batch expired", "batch", b.id)
+		}
+		return true
+	})
+}
+
+// list returns the local batches created by owner, newest first
+func (s *batchStore) list(owner string) []*localBatch {
+	var batches []*localBatch
+	s.batches.Range(func(_, value interface{}) bool {
+		if b := value.(*localBatch); b.owner == owner {
+			batches = append(batches, b)
+		}
+		return true
+	})
+	sort.Slice(batches, func(i, j int) bool { return batches[i].createdAt.After(batches[j].createdAt) })
+	return batches
+}
+
+// get returns the local batch with the given ID if owner created it, otherwise nil
+func (s *batchStore) get(id, owner string) *localBatch {
+	if b, ok := s.batches.Load(id); ok && b.(*localBatch).owner == owner {
+		return b.(*localBatch)
+	}
+	return nil
+}
+
+// batchUpstream returns the upstream holding the batches that aren't processed locally.
+// Requests about a batch carry no model, so batches ignore model_routes and stay on the
+// default upstream, where the requests about unknown batch IDs are proxied too.
+func (p *Proxy) batchUpstream() *UpstreamPool {
+	return p.upstreamFor("")
+}
+
+// isBatchRequest checks if the path targets the Message Batches API
+func isBatchRequest(path string) bool {
+	return strings.HasSuffix(path, batchesPathSuffix) || strings.Contains(path, batchesPathSuffix+"/")
+}
+
+// handleBatches routes Message Batches API requests. Batches containing web_search items
+// are split between local Gemini processing and an upstream batch; everything else is proxied.
+func (p *Proxy) handleBatches(w http.ResponseWriter, r *http.Request, path string) {
+	if strings.HasSuffix(path, batchesPathSuffix) {
+		switch r.Method {
+		case http.MethodPost:
+			p.handleBatchCreate(w, r)
+		case http.MethodGet:
+			p.listBatches(w, r)
+		default:
+			p.proxyOrReject(w, r, "")
+		}
+		return
+	}
+
+	// /v1/messages/batches/{id}[/results|/cancel]. Batches of other clients are treated
+	// as unknown, like the Messages API scopes batches to the API key.
+	_, rest, _ := strings.Cut(path, batchesPathSuffix+"/")
+	id, action, _ := strings.Cut(rest, "/")
+	batch := p.batches.get(id, p.clientKeyHash(r))
+	if batch == nil {
+		p.proxyOrReject(w, r, "")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		p.writeBatchStatus(w, r, batch)
+	case r.Method == http.MethodGet && action == "results":
+		p.writeBatchResults(w, r, batch)
+	case r.Method == http.MethodPost && action == "cancel":
+		p.cancelBatch(w, r, batch)
+	case r.Method == http.MethodDelete && action == "":
+		// Like the Messages API, only ended batches can be deleted; in-progress ones must
+		// be canceled first
+		if p.batchStatus(r, batch)["processing_status"] != "ended" {
+			writeError(w, http.StatusBadRequest, errTypeInvalidRequest,
+				fmt.Sprintf("Batch %s cannot be deleted while it is still processing. Cancel it first.", id))
+			return
+		}
+		p.batches.batches.Delete(id)
+		if batch.upstreamID != "" {
+			p.doUpstreamRequest(r.Context(), p.batchUpstream(), r, http.MethodDelete, batchPath(path, batch.upstreamID, ""), nil)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(`{"id":%q,"type":"message_batch_deleted"}`, id)))
+	default:
+		writeError(w, http.StatusNotFound, errTypeNotFound, "Unsupported batch operation")
+	}
+}
+
+// handleBatchCreate splits a new batch into web_search items processed locally
+// and remaining items submitted to the upstream
+func (p *Proxy) handleBatchCreate(w http.ResponseWriter, r *http.Request) {
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	var local, remote []gjson.Result
+	for _, item := range gjson.GetBytes(body, "requests").Array() {
+		params := []byte(item.Get("params").Raw)
+		if IsClaudeModel(GetModel(params)) && HasWebSearchTool(params) {
+			local = append(local, item)
+		} else {
+			remote = append(remote, item)
+		}
+	}
+
+	if len(local) == 0 {
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, "")
+		return
+	}
+
+	batch := &localBatch{
+		id:        "msgbatch_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+		owner:     p.clientKeyHash(r),
+		createdAt: time.Now().UTC(),
+		total:     len(local),
+	}
+
+	if len(remote) > 0 {
+		if p.batchUpstream() == nil {
+			for _, item := range remote {
+				batch.addResult(item.Get("custom_id").String(), "errored", "",
+					"No upstream configured and request is not a web_search request")
+			}
+			batch.total += len(remote)
+		} else {
+			upstreamID, err := p.createUpstreamBatch(r, remote)
+			if err != nil {
+				slog.Error("Failed to create upstream batch", "error", err)
+				writeError(w, http.StatusBadGateway, errTypeAPI, "Failed to create upstream batch")
+				return
+			}
+			batch.upstreamID = upstreamID
+		}
+	}
+
+	// Local items outlive the client request, so they get their own context
+	ctx, cancel := context.WithCancel(withSearchBackend(
+		withKeyLabel(context.Background(), keyLabelFrom(r.Context())), searchBackendFrom(r.Context())))
+	batch.cancel = cancel
+	p.batches.batches.Store(batch.id, batch)
+
+	slog.Info("Batch created", "batch", batch.id, "gemini_items", len(local), "upstream_items", len(remote))
+	go p.processBatchItems(ctx, batch, local)
+
+	p.writeBatchStatus(w, r, batch)
+}
+
+// createUpstreamBatch submits the given batch items to the upstream and returns its batch ID
+func (p *Proxy) createUpstreamBatch(r *http.Request, items []gjson.Result) (string, error) {
+	raw := make([]string, len(items))
+	for i, item := range items {
+		raw[i] = item.Raw
+	}
+	body := []byte(`{"requests":[` + strings.Join(raw, ",") + `]}`)
+
+	status, resp, err := p.doUpstreamRequest(r.Context(), p.batchUpstream(), r, http.MethodPost, r.URL.Path, body)
+	if err != nil {
+		return "", err
+	}
+	if status < 200 || status >= 300 {
+		return "", fmt.Errorf("upstream returned status %d", status)
+	}
+	id := gjson.GetBytes(resp, "id").String()
+	if id == "" {
+		return "", fmt.Errorf("upstream batch response has no id")
+	}
+	return id, nil
+}
+
+// processBatchItems runs each web_search item through the Gemini path
+func (p *Proxy) processBatchItems(ctx context.Context, batch *localBatch, items []gjson.Result) {
+	for _, item := range items {
+		customID := item.Get("custom_id").String()
+		if ctx.Err() != nil {
+			batch.addResult(customID, "canceled", "", "")
+			continue
+		}
+
+		params := []byte(item.Get("params").Raw)
+		geminiResp, err := p.executeSearch(ctx, params)
+		if err != nil {
+			slog.Warn("Batch item web search failed", "batch", batch.id, "custom_id", customID, "error", err)
+			batch.addResult(customID, "errored", "", "Web search temporarily unavailable")
+			continue
+		}
+		batch.addResult(customID, "succeeded", ConvertToClaudeNonStream(ctx, GetModel(params), geminiResp, p.urlResolver), "")
+	}
+
+	batch.mu.Lock()
+	batch.endedAt = time.Now().UTC()
+	batch.mu.Unlock()
+}
+
+// addResult records the result of a single locally processed batch item
+func (b *localBatch) addResult(customID, resultType, message, errMsg string) {
+	line, _ := sjson.Set(`{"custom_id":"","result":{"type":""}}`, "custom_id", customID)
+	line, _ = sjson.Set(line, "result.type", resultType)
+	switch resultType {
+	case "succeeded":
+		line, _ = sjson.SetRaw(line, "result.message", message)
+	case "errored":
+		line, _ = sjson.Set(line, "result.error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": "api_error", "message": errMsg},
+		})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.results = append(b.results, line)
+	switch resultType {
+	case "succeeded":
+		b.succeeded++
+	case "errored":
+		b.errored++
+	case "canceled":
+		b.canceled++
+	}
+}
+
+// writeBatchStatus writes the merged status of the local and upstream parts of a batch
+func (p *Proxy) writeBatchStatus(w http.ResponseWriter, r *http.Request, batch *localBatch) {
+	resp, _ := json.Marshal(p.batchStatus(r, batch))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// listBatches lists the client's local batches ahead of its upstream batches, leaving out
+// the upstream batches holding the upstream part of a local one. A page ending on a local
+// batch continues with the local batches after it and then the upstream ones; pages
+// after an upstream batch, or before any batch, come from the upstream alone.
+func (p *Proxy) listBatches(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	owned := p.batches.list(p.clientKeyHash(r))
+
+	local := owned
+	if afterID := query.Get("after_id"); afterID != "" {
+		i := 0
+		for i < len(owned) && owned[i].id != afterID {
+			i++
+		}
+		if i == len(owned) {
+			p.proxyOrReject(w, r, "")
+			return
+		}
+		local = owned[i+1:]
+	} else if len(owned) == 0 || query.Get("before_id") != "" {
+		p.proxyOrReject(w, r, "")
+		return
+	}
+
+	limit := defaultBatchListLimit
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxBatchListLimit)
+	}
+	hasMore := len(local) > limit
+	if hasMore {
+		local = local[:limit]
+	}
+
+	data := make([]interface{}, 0, limit)
+	var ids []string
+	for _, batch := range local {
+		data = append(data, p.batchStatus(r, batch))
+		ids = append(ids, batch.id)
+	}
+
+	if upstream := p.batchUpstream(); upstream != nil && !hasMore {
+		if len(local) == limit {
+			// The upstream batches start on the next page
+			hasMore = true
+		} else {
+			upstreamParts := make(map[string]bool)
+			for _, batch := range owned {
+				if batch.upstreamID != "" {
+					upstreamParts[batch.upstreamID] = true
+				}
+			}
+			upstreamQuery := r.URL.Query()
+			upstreamQuery.Del("after_id")
+			upstreamQuery.Set("limit", strconv.Itoa(limit-len(local)))
+			code, resp, err := p.doUpstreamRequest(r.Context(), upstream, r, http.MethodGet, r.URL.Path+"?"+upstreamQuery.Encode(), nil)
+			if err != nil || code < 200 || code >= 300 {
+				slog.Warn("Failed to list upstream batches", "status", code, "error", err)
+				writeError(w, http.StatusBadGateway, errTypeAPI, "Failed to list upstream batches")
+				return
+			}
+			for _, item := range gjson.GetBytes(resp, "data").Array() {
+				if id := item.Get("id").String(); !upstreamParts[id] {
+					data = append(data, json.RawMessage(item.Raw))
+					ids = append(ids, id)
+				}
+			}
+			hasMore = gjson.GetBytes(resp, "has_more").Bool()
+		}
+	}
+
+	obj := map[string]interface{}{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": nil,
+		"last_id":  nil,
+	}
+	if len(ids) > 0 {
+		obj["first_id"], obj["last_id"] = ids[0], ids[len(ids)-1]
+	}
+	resp, _ := json.Marshal(obj)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// batchStatus builds the message_batch object merging the local and upstream parts of a batch
+func (p *Proxy) batchStatus(r *http.Request, batch *localBatch) map[string]interface{} {
+	batch.mu.Lock()
+	counts := map[string]int{
+		"processing": batch.total - len(batch.results),
+		"succeeded":  batch.succeeded,
+		"errored":    batch.errored,
+		"canceled":   batch.canceled,
+		"expired":    0,
+	}
+	localEnded := !batch.endedAt.IsZero()
+	endedAt, canceledAt, createdAt := batch.endedAt, batch.canceledAt, batch.createdAt
+	batch.mu.Unlock()
+
+	if batch.upstreamID != "" {
+		code, resp, err := p.doUpstreamRequest(r.Context(), p.batchUpstream(), r, http.MethodGet, batchPath(r.URL.Path, batch.upstreamID, ""), nil)
+		if err != nil || code < 200 || code >= 300 {
+			slog.Warn("Failed to fetch upstream batch status", "batch", batch.id, "upstream_batch", batch.upstreamID, "status", code, "error", err)
+			localEnded = false
+		} else {
+			for name := range counts {
+				counts[name] += int(gjson.GetBytes(resp, "request_counts."+name).Int())
+			}
+			if gjson.GetBytes(resp, "processing_status").String() != "ended" {
+				localEnded = false
+			} else if t := gjson.GetBytes(resp, "ended_at").Time(); t.After(endedAt) {
+				endedAt = t
+			}
+		}
+	}
+
+	obj := map[string]interface{}{
+		"id":                  batch.id,
+		"type":                "message_batch",
+		"processing_status":   "in_progress",
+		"request_counts":      counts,
+		"created_at":          createdAt.Format(time.RFC3339),
+		"expires_at":          createdAt.Add(batchExpiry).Format(time.RFC3339),
+		"ended_at":            nil,
+		"archived_at":         nil,
+		"cancel_initiated_at": nil,
+		"results_url":         nil,
+	}
+	if !canceledAt.IsZero() {
+		obj["processing_status"] = "canceling"
+		obj["cancel_initiated_at"] = canceledAt.Format(time.RFC3339)
+	}
+	if localEnded {
+		obj["processing_status"] = "ended"
+		obj["ended_at"] = endedAt.Format(time.RFC3339)
+		obj["results_url"] = p.externalURL(r, batchPath(r.URL.Path, batch.id, "results"))
+	}
+	return obj
+}
+
+// writeBatchResults writes the merged JSONL results of the local and upstream parts of a batch
+func (p *Proxy) writeBatchResults(w http.ResponseWriter, r *http.Request, batch *localBatch) {
+	batch.mu.Lock()
+	ended := !batch.endedAt.IsZero()
+	lines := append([]string(nil), batch.results...)
+	batch.mu.Unlock()
+
+	if !ended {
+		writeError(w, http.StatusConflict, errTypeInvalidRequest, "Batch is still processing")
+		return
+	}
+
+	if batch.upstreamID != "" {
+		code, resp, err := p.doUpstreamRequest(r.Context(), p.batchUpstream(), r, http.MethodGet, batchPath(r.URL.Path, batch.upstreamID, "results"), nil)
+		if err != nil || code < 200 || code >= 300 {
+			slog.Warn("Failed to fetch upstream batch results", "batch", batch.id, "status", code, "error", err)
+			writeError(w, http.StatusBadGateway, errTypeAPI, "Failed to fetch upstream batch results")
+			return
+		}
+		if trimmed := strings.TrimSpace(string(resp)); trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-jsonl")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+// cancelBatch cancels the local processing and the upstream part of a batch
+func (p *Proxy) cancelBatch(w http.ResponseWriter, r *http.Request, batch *localBatch) {
+	batch.mu.Lock()
+	if batch.canceledAt.IsZero() {
+		batch.canceledAt = time.Now().UTC()
+	}
+	batch.mu.Unlock()
+	batch.cancel()
+
+	if batch.upstreamID != "" {
+		if _, _, err := p.doUpstreamRequest(r.Context(), p.batchUpstream(), r, http.MethodPost, batchPath(r.URL.Path, batch.upstreamID, "cancel"), []byte(`{}`)); err != nil {
+			slog.Warn("Failed to cancel upstream batch", "batch", batch.id, "upstream_batch", batch.upstreamID, "error", err)
+		}
+	}
+
+	p.writeBatchStatus(w, r, batch)
+}
+
+// batchPath rewrites a batch request path to target the given batch ID and action
+func batchPath(path, id, action string) string {
+	base := path[:strings.Index(path, batchesPathSuffix)+len(batchesPathSuffix)]
+	if action == "" {
+		return base + "/" + id
+	}
+	return base + "/" + id + "/" + action
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// errBudgetExhausted is returned instead of searching once the daily budget is spent
+var errBudgetExhausted = errors.New("daily web search budget exhausted")
+
+// dailyUsage is the Gemini usage accumulated on a single UTC day
+type dailyUsage struct {
+	Day              string           `json:"day"`
+	Searches         int64            `json:"searches"`
+	PromptTokens     int64            `json:"prompt_tokens"`
+	CandidatesTokens int64            `json:"candidates_tokens"`
+	KeyRequests      map[string]int64 `json:"key_requests,omitempty"` // Gemini requests by key ID
+}
+
+// UsageTracker accounts Gemini searches and tokens per UTC day and enforces the
+// configured daily budgets. When path is set the counters survive restarts.
+type UsageTracker struct {
+	mu           sync.Mutex
+	path         string
+	searchBudget int64
+	tokenBudget  int64
+	today        dailyUsage
+	exhaustedDay string // day on which budget exhaustion was last logged
+}
+
+// NewUsageTracker creates a tracker, loading today's counters from path if it exists
+func NewUsageTracker(path string, searchBudget, tokenBudget int64) (*UsageTracker, error) {
+	t := &UsageTracker{path: path, searchBudget: searchBudget, tokenBudget: tokenBudget}
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.today); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// rollover resets the counters when the UTC day has changed; callers hold t.mu
+func (t *UsageTracker) rollover() {
+	if day := time.Now().UTC().Format(time.DateOnly); t.today.Day != day {
+		t.today = dailyUsage{Day: day}
+	}
+}
+
+// Exhausted reports whether either daily budget has been reached
+func (t *UsageTracker) Exhausted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+
+	exhausted := (t.searchBudget > 0 && t.today.Searches >= t.searchBudget) ||
+		(t.tokenBudget > 0 && t.today.PromptTokens+t.today.CandidatesTokens >= t.tokenBudget)
+	if exhausted && t.exhaustedDay != t.today.Day {
+		t.exhaustedDay = t.today.Day
+		slog.Warn("Daily web search budget exhausted", "day", t.today.Day, "searches", t.today.Searches,
+			"tokens", t.today.PromptTokens+t.today.CandidatesTokens)
+	}
+	return exhausted
+}
+
+// Record adds a completed search and its token usage to today's counters
+func (t *UsageTracker) Record(geminiResp []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+
+	t.today.Searches++
+	t.today.PromptTokens += getUsageField(geminiResp, "promptTokenCount")
+	t.today.CandidatesTokens += getUsageField(geminiResp, "candidatesTokenCount")
+	t.save()
+}
+
+// RecordKeyRequest counts a request sent to Gemini with a key and returns the key's
+// request count for today
+func (t *UsageTracker) RecordKeyRequest(keyID string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+
+	if t.today.KeyRequests == nil {
+		t.today.KeyRequests = make(map[string]int64)
+	}
+	t.today.KeyRequests[keyID]++
+	t.save()
+	return t.today.KeyRequests[keyID]
+}
+
+// KeyRequests returns today's request count of a key
+func (t *UsageTracker) KeyRequests(keyID string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.today.KeyRequests[keyID]
+}
+
+// Today returns a copy of today's counters
+func (t *UsageTracker) Today() dailyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	today := t.today
+	today.KeyRequests = make(map[string]int64, len(t.today.KeyRequests))
+	for id, n := range t.today.KeyRequests {
+		today.KeyRequests[id] = n
+	}
+	return today
+}
+
+// untilNextDay returns the time until the usage counters roll over at UTC midnight
+func untilNextDay() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// save persists the counters atomically; callers hold t.mu
+func (t *UsageTracker) save() {
+	if t.path == "" {
+		return
+	}
+	data, _ := json.Marshal(t.today)
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), ".usage-*")
+	if err == nil {
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), t.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to persist usage counters", "path", t.path, "error", err)
+	}
+}
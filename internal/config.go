@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"encoding/base64"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,12 +16,27 @@ type Config struct {
 	// Listen host for the proxy (default: 127.0.0.1)
 	ListenHost string `yaml:"listen_host"`
 
-	// Listen port for the proxy
+	// Listen port for the proxy (0 disables the TCP listener)
 	ListenPort int `yaml:"listen_port"`
 
+	// Unix domain socket path to listen on, instead of or in addition to TCP
+	ListenSocket string `yaml:"listen_socket"`
+
 	// Upstream URL (CLIProxyAPI or other Claude API proxy)
 	UpstreamURL string `yaml:"upstream_url"`
 
+	// Upstream URLs in failover priority order (overrides UpstreamURL when set)
+	UpstreamURLs []string `yaml:"upstream_urls"`
+
+	// Interval in seconds between upstream health checks when multiple upstreams are configured
+	UpstreamHealthInterval int `yaml:"upstream_health_interval"`
+
+	// Path probed on each upstream by the health check
+	UpstreamHealthPath string `yaml:"upstream_health_path"`
+
+	// Model-based routing rules, evaluated in order before the default upstream
+	UpstreamRoutes []UpstreamRoute `yaml:"upstream_routes"`
+
 	// Gemini API key for web search
 	GeminiAPIKey string `yaml:"gemini_api_key"`
 
@@ -29,27 +48,424 @@ type Config struct {
 
 	// Logging level: debug, info, warn, error
 	LogLevel string `yaml:"log_level"`
+
+	// Interval in seconds between SSE ping events while a streaming web search
+	// is in progress (0 disables pings)
+	SSEPingInterval int `yaml:"sse_ping_interval"`
+
+	// Forward the request upstream without the web_search tool when the
+	// Gemini search fails, instead of returning an error
+	WebSearchFallback bool `yaml:"web_search_fallback"`
+
+	// Pass client tools to Gemini as function declarations and map Gemini
+	// function calls back to Claude tool_use blocks
+	HybridTools bool `yaml:"hybrid_tools"`
+
+	// How intercepted web_search requests are answered: "replace" (Gemini writes
+	// the answer) or "orchestrate" (Gemini searches, upstream Claude answers)
+	WebSearchMode string `yaml:"web_search_mode"`
+
+	// When to intercept requests declaring web_search: "always", or "tool_choice"
+	// (only when tool_choice forces it or the last assistant turn requested it)
+	InterceptMode string `yaml:"intercept_mode"`
+
+	// Maximum number of web searches in flight at once (0 = unlimited)
+	MaxConcurrentSearches int `yaml:"max_concurrent_searches"`
+
+	// PEM certificate and key files; when both are set the TCP listener serves HTTPS
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+
+	// PEM CA bundle; when set, TLS clients must present a certificate signed by it
+	TLSClientCA string `yaml:"tls_client_ca"`
+
+	// API keys clients must present via x-api-key or Authorization (empty = no auth)
+	ProxyAPIKeys []string `yaml:"proxy_api_keys"`
+
+	// Source IPs/CIDRs allowed to connect over TCP (empty = allow all)
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+
+	// Browser origins allowed to call the proxy via CORS ("*" allows any; empty disables CORS)
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+
+	// Response headers exposed to browser clients (default: request-id, retry-after)
+	CORSExposeHeaders []string `yaml:"cors_expose_headers"`
+
+	// Header rules applied to requests forwarded to the upstream
+	UpstreamHeaders HeaderRules `yaml:"upstream_headers"`
+
+	// Header rules applied to requests sent to the Gemini API. Client headers are never
+	// copied to Gemini requests, so client credentials cannot leak there.
+	GeminiHeaders HeaderRules `yaml:"gemini_headers"`
+
+	// Retries for upstream requests that were refused, or idempotent ones answered 502/503
+	// (0 disables)
+	UpstreamRetries int `yaml:"upstream_retries"`
+
+	// Initial delay in milliseconds between upstream retries, doubled on each attempt
+	UpstreamRetryBackoff int `yaml:"upstream_retry_backoff_ms"`
+
+	// Proxy URL (http, https or socks5) for requests to Google; defaults to HTTPS_PROXY
+	OutboundProxy string `yaml:"outbound_proxy"`
+
+	// PEM CA bundle trusted for requests to Google, in addition to the system roots
+	CABundle string `yaml:"ca_bundle"`
+
+	// Skip TLS certificate verification for requests to Google (insecure)
+	TLSInsecureSkipVerify bool `yaml:"tls_insecure_skip_verify"`
+
+	// Idle connections kept per host by the upstream and Google HTTP clients
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+
+	// Seconds an idle connection is kept before closing (0 = no limit)
+	IdleConnTimeout int `yaml:"idle_conn_timeout"`
+
+	// TCP keep-alive period in seconds for outgoing connections (negative disables)
+	KeepAlive int `yaml:"keep_alive"`
+
+	// Disable HTTP/2 for outgoing connections
+	DisableHTTP2 bool `yaml:"disable_http2"`
+
+	// Minimum size in bytes of non-streaming JSON responses to gzip for clients that
+	// accept it (0 disables compression)
+	CompressMinBytes int `yaml:"compress_min_bytes"`
+
+	// Seconds to let in-flight requests (including SSE streams) finish on shutdown
+	ShutdownDrainTimeout int `yaml:"shutdown_drain_timeout"`
+
+	// Bind the TCP listener with SO_REUSEPORT so a new instance can take over the port
+	// while the old one drains
+	ListenReusePort bool `yaml:"listen_reuse_port"`
+
+	// TCP addresses (host:port) to listen on concurrently, replacing ListenHost/ListenPort
+	ListenAddresses []string `yaml:"listen_addresses"`
+
+	// Path prefix the proxy is served under behind a reverse proxy (e.g. /claude);
+	// X-Forwarded-Prefix overrides it per request
+	BasePath string `yaml:"base_path"`
+
+	// Percentage of web_search conversations routed through Gemini; the rest are
+	// forwarded upstream untouched (default: 100)
+	InterceptPercent int `yaml:"intercept_percent"`
+
+	// Forward web_search requests upstream unchanged and run the Gemini search in the
+	// background only to log its would-be response
+	ShadowMode bool `yaml:"shadow_mode"`
+
+	// Directory where shadow mode records each request and would-be response as JSON
+	ShadowLogDir string `yaml:"shadow_log_dir"`
+
+	// Log output format: text or json
+	LogFormat string `yaml:"log_format"`
+
+	// Append-only JSON Lines file recording every web search (empty disables)
+	AuditLog string `yaml:"audit_log"`
+
+	// Directory where each intercepted request's Claude payload, Gemini request, Gemini
+	// response and converted output are recorded, with secrets redacted (empty disables)
+	DebugRecordDir string `yaml:"debug_record_dir"`
+
+	// Webhook receiving Slack-compatible JSON alerts when the Gemini key is rejected or
+	// out of quota (empty disables)
+	AlertWebhookURL string `yaml:"alert_webhook_url"`
+
+	// Minimum seconds between two alerts of the same kind
+	AlertCooldown int `yaml:"alert_cooldown"`
+
+	// Directory where the exact SSE stream sent for each intercepted request is captured
+	// (empty disables); captures can be replayed with the replay subcommand
+	SSECaptureDir string `yaml:"sse_capture_dir"`
+
+	// Run a test search at startup to verify Gemini credentials and connectivity
+	StartupSelfTest bool `yaml:"startup_self_test"`
+
+	// File persisting the daily Gemini usage counters across restarts (empty: in memory only)
+	UsageFile string `yaml:"usage_file"`
+
+	// Daily limits on Gemini searches and tokens (0: unlimited). Once reached, web_search
+	// requests fall back upstream or get a too_many_requests tool error until midnight UTC.
+	DailySearchBudget int64 `yaml:"daily_search_budget"`
+	DailyTokenBudget  int64 `yaml:"daily_token_budget"`
+
+	// Web search requests taking at least this long are logged at warn level with their
+	// phase breakdown (0: disabled)
+	SlowRequestThreshold int `yaml:"slow_request_threshold_ms"`
+
+	// StatsD/DogStatsD exporter: agent host:port (empty: disabled), metric name prefix,
+	// DogStatsD tags and counter flush interval in seconds
+	StatsDAddress       string   `yaml:"statsd_address"`
+	StatsDPrefix        string   `yaml:"statsd_prefix"`
+	StatsDTags          []string `yaml:"statsd_tags"`
+	StatsDFlushInterval int      `yaml:"statsd_flush_interval"`
+
+	// Log sink: stderr, syslog or journald (stderr with priority prefixes)
+	LogOutput string `yaml:"log_output"`
+
+	// Token protecting the /admin API (empty: admin API disabled)
+	AdminToken string `yaml:"admin_token"`
+
+	// Retries of Gemini requests failing with network errors or 5xx responses.
+	// Authentication and quota errors are never retried.
+	GeminiRetries int `yaml:"gemini_retries"`
+
+	// How the proxy authenticates to Gemini: api_key (gemini_api_key) or adc
+	// (Google Application Default Credentials)
+	GeminiAuth string `yaml:"gemini_auth"`
+
+	// Credentials file for gemini_auth: adc or vertex (service account key or authorized_user JSON),
+	// taking precedence over GOOGLE_APPLICATION_CREDENTIALS
+	GeminiCredentialsFile string `yaml:"gemini_credentials_file"`
+
+	// Google Cloud project and location used with gemini_auth: vertex
+	VertexProject  string `yaml:"vertex_project"`
+	VertexLocation string `yaml:"vertex_location"`
+
+	// Gemini API keys rotated when one is rejected or out of quota (overrides gemini_api_key when set)
+	GeminiAPIKeys []string `yaml:"gemini_api_keys"`
+
+	// Seconds a Gemini API key rejected with 401/403 is left out of the rotation
+	GeminiKeyFailCooldown int `yaml:"gemini_key_fail_cooldown"`
+
+	// Files holding secrets, e.g. Docker or Kubernetes secrets, read instead of the
+	// corresponding setting. Key list files hold one key per line.
+	GeminiAPIKeyFile    string `yaml:"gemini_api_key_file"`
+	ProxyAPIKeysFile    string `yaml:"proxy_api_keys_file"`
+	AdminTokenFile      string `yaml:"admin_token_file"`
+	AlertWebhookURLFile string `yaml:"alert_webhook_url_file"`
+
+	// Milliseconds after which a slow Gemini request is repeated with another API key in
+	// parallel; the first response wins and the other request is cancelled (0 = off)
+	GeminiHedgeAfter int `yaml:"gemini_hedge_after_ms"`
+
+	// Limits per Gemini key: concurrent requests and requests per minute (0 = unlimited).
+	// Requests go to the next key when one is at its limit and wait when all are.
+	GeminiKeyMaxConcurrent int `yaml:"gemini_key_max_concurrent"`
+	GeminiKeyRPM           int `yaml:"gemini_key_requests_per_minute"`
+
+	// Labels of Gemini keys by key ID (as shown by /admin/auth), e.g. key-1a2b3c4d: [tier=paid].
+	// A request carrying a label via x-gemini-key-label, or the label of its proxy API key
+	// in proxy_api_key_labels, only uses keys with that label.
+	GeminiKeyLabels   map[string][]string `yaml:"gemini_key_labels"`
+	ProxyAPIKeyLabels map[string]string   `yaml:"proxy_api_key_labels"`
+
+	// Credentials for gemini_auth: adc or vertex given inline, as JSON or base64-encoded JSON,
+	// for deployments without a credentials file. Takes precedence over gemini_credentials_file.
+	GeminiCredentialsJSON string `yaml:"gemini_credentials_json"`
+
+	// How searches pick a Gemini key: sticky (stay on one key until it fails) or
+	// least_loaded (fewest requests in flight, weighted by recent errors)
+	GeminiKeySelection string `yaml:"gemini_key_selection"`
+
+	// Daily request limit of each Gemini key, e.g. a free-tier cap, and overrides by key ID
+	// (0 = unlimited). A key at its limit is skipped until UTC midnight; counts persist in usage_file.
+	GeminiKeyDailyLimit  int64            `yaml:"gemini_key_daily_limit"`
+	GeminiKeyDailyLimits map[string]int64 `yaml:"gemini_key_daily_limits"`
+
+	// Search engine behind web searches: gemini (googleSearch grounding) or another
+	// registered backend
+	SearchBackend string `yaml:"search_backend"`
+
+	// Serper.dev API key and base URL for search_backend: serper
+	SerperAPIKey  string `yaml:"serper_api_key"`
+	SerperBaseURL string `yaml:"serper_base_url"`
+
+	// Exa API key and base URL for search_backend: exa
+	ExaAPIKey  string `yaml:"exa_api_key"`
+	ExaBaseURL string `yaml:"exa_base_url"`
+
+	// DuckDuckGo base URL for search_backend: duckduckgo, the fallback without a Gemini key
+	DuckDuckGoBaseURL string `yaml:"duckduckgo_base_url"`
+
+	// Custom Search JSON API key, search engine ID (cx) and base URL for search_backend: google_cse
+	GoogleCSEAPIKey  string `yaml:"google_cse_api_key"`
+	GoogleCSECX      string `yaml:"google_cse_cx"`
+	GoogleCSEBaseURL string `yaml:"google_cse_base_url"`
+
+	// Backends tried in order when search_backend fails or is rate limited, e.g. [serper, duckduckgo].
+	// A failed backend is tried last for search_backend_cooldown seconds, or as long as its quota error says.
+	SearchFallbackBackends []string `yaml:"search_fallback_backends"`
+	SearchBackendCooldown  int      `yaml:"search_backend_cooldown"`
+
+	// Search backend by proxy API key, for clients that should use another engine than
+	// search_backend. Requests can also pick one with the x-websearch-backend header.
+	ProxyAPIKeyBackends map[string]string `yaml:"proxy_api_key_backends"`
+
+	// Fetch the pages of the top results after a search and pass up to enrich_max_chars of
+	// their text in the web_search_result blocks (0 = off), waiting at most enrich_timeout_ms
+	EnrichTopResults int `yaml:"enrich_top_results"`
+	EnrichMaxChars   int `yaml:"enrich_max_chars"`
+	EnrichTimeout    int `yaml:"enrich_timeout_ms"`
+
+	// Number of results requested from search engines other than Gemini
+	SearchMaxResults int `yaml:"search_max_results"`
+
+	// Most characters of page text a web_fetch result carries, lowered by the tool's
+	// max_content_tokens
+	WebFetchMaxChars int `yaml:"web_fetch_max_chars"`
+
+	// Add Gemini's urlContext tool to searches whose user message contains URLs, so the
+	// answer is grounded in those pages
+	URLContext bool `yaml:"url_context"`
+
+	// Search with googleSearchRetrieval in dynamic mode instead of always-on googleSearch:
+	// Gemini only searches when it rates the need for a search at least this high
+	// (0 to 1, 0 = always search with googleSearch)
+	SearchDynamicThreshold float64 `yaml:"search_dynamic_threshold"`
+
+	// Post-search stage: drop results repeating the URL or title of a previous one, order
+	// them by result_ranking (none, domain or recency) and keep at most result_max_count
+	// (0 = all). The domain ranking moves preferred_domains up and demoted_domains down.
+	ResultDedupe     bool     `yaml:"result_dedupe"`
+	ResultRanking    string   `yaml:"result_ranking"`
+	PreferredDomains []string `yaml:"preferred_domains"`
+	DemotedDomains   []string `yaml:"demoted_domains"`
+	ResultMaxCount   int      `yaml:"result_max_count"`
+
+	// Results dropped from every search: results from these domains and results whose
+	// URL or title matches one of these regular expressions
+	ResultBlockedDomains       []string `yaml:"result_blocked_domains"`
+	ResultBlockedURLPatterns   []string `yaml:"result_blocked_url_patterns"`
+	ResultBlockedTitlePatterns []string `yaml:"result_blocked_title_patterns"`
+
+	// Backends queried concurrently with the search backend of every search, e.g. [exa],
+	// for higher recall: their results are merged with its results, duplicates removed
+	SearchFanOutBackends []string `yaml:"search_fanout_backends"`
+
+	// Ask the search model for 1-3 focused search queries from the conversation before
+	// searching, instead of searching for the last user message. Needs Gemini credentials.
+	QueryRewrite bool `yaml:"query_rewrite"`
+
+	// Let web_fetch and enrichment fetch pages on loopback, private and link-local
+	// addresses. Off by default, so clients can't use the proxy to reach internal hosts.
+	FetchPrivateAddresses bool `yaml:"fetch_private_addresses"`
+
+	// Obtain and renew the HTTPS certificate for these domains from Let's Encrypt (ACME
+	// TLS-ALPN-01, so the listener must be reachable on port 443), instead of tls_cert/tls_key
+	TLSAutocertDomains []string `yaml:"tls_autocert_domains"`
+	// Directory caching the ACME account and certificates (default: the user cache directory)
+	TLSAutocertCacheDir string `yaml:"tls_autocert_cache_dir"`
+	// Contact address given to Let's Encrypt for expiry and problem notices
+	TLSAutocertEmail string `yaml:"tls_autocert_email"`
+
+	// path is the file the config was loaded from, for reloads
+	path string
 }
 
 // Default values
 const (
-	DefaultWebSearchModel = "gemini-2.5-flash"
-	DefaultUpstreamURL    = "http://localhost:8317"
-	DefaultListenHost     = "127.0.0.1"
-	DefaultListenPort     = 8318
-	DefaultLogLevel       = "info"
+	DefaultWebSearchModel  = "gemini-2.5-flash"
+	DefaultUpstreamURL     = "http://localhost:8317"
+	DefaultListenHost      = "127.0.0.1"
+	DefaultListenPort      = 8318
+	DefaultLogLevel        = "info"
+	DefaultLogFormat       = LogFormatText
+	DefaultLogOutput       = LogOutputStderr
+	DefaultSSEPingInterval = 10
+	DefaultHealthInterval  = 30
+	DefaultHealthPath      = "/"
+	DefaultWebSearchMode   = WebSearchModeReplace
+	DefaultInterceptMode   = InterceptModeAlways
+	DefaultUpstreamRetries = 2
+	DefaultRetryBackoff    = 250
+	DefaultMaxIdlePerHost  = 32
+	DefaultIdleConnTimeout = 90
+	DefaultKeepAlive       = 30
+	DefaultCompressMin     = 4096
+	DefaultDrainTimeout    = 60
+	DefaultInterceptPct    = 100
+	DefaultAlertCooldown   = 900
+	DefaultStatsDPrefix    = "cpa_websearch_proxy."
+	DefaultStatsDFlush     = 10
+	DefaultGeminiRetries   = 1
+	DefaultGeminiAuth      = GeminiAuthAPIKey
+	DefaultVertexLocation  = "us-central1"
+	DefaultKeyFailCooldown = 300
+	DefaultKeySelection    = KeySelectionSticky
+	DefaultSearchBackend   = SearchBackendGemini
+	DefaultSerperBaseURL   = "https://google.serper.dev"
+	DefaultExaBaseURL      = "https://api.exa.ai"
+	DefaultDuckDuckGoURL   = "https://html.duckduckgo.com"
+	DefaultGoogleCSEURL    = "https://www.googleapis.com"
+	DefaultBackendCooldown = 60
+	DefaultEnrichMaxChars  = 2000
+	DefaultEnrichTimeout   = 5000
+	DefaultSearchResults   = 10
+	DefaultWebFetchChars   = 100000
+)
+
+// Web search modes
+const (
+	WebSearchModeReplace     = "replace"
+	WebSearchModeOrchestrate = "orchestrate"
+)
+
+// Intercept modes
+const (
+	InterceptModeAlways     = "always"
+	InterceptModeToolChoice = "tool_choice"
+)
+
+// Gemini key selection strategies
+const (
+	KeySelectionSticky      = "sticky"
+	KeySelectionLeastLoaded = "least_loaded"
+)
+
+// Gemini authentication modes
+const (
+	GeminiAuthAPIKey = "api_key"
+	GeminiAuthADC    = "adc"
+	GeminiAuthVertex = "vertex"
 )
 
 // LoadConfig loads configuration from a YAML file or environment variables
 func LoadConfig(path string) (*Config, error) {
 	cfg := &Config{
-		ListenHost:     DefaultListenHost,
-		ListenPort:     DefaultListenPort,
-		UpstreamURL:    DefaultUpstreamURL,
-		WebSearchModel: DefaultWebSearchModel,
-		LogLevel:       DefaultLogLevel,
+		ListenHost:             DefaultListenHost,
+		ListenPort:             DefaultListenPort,
+		UpstreamURL:            DefaultUpstreamURL,
+		UpstreamHealthInterval: DefaultHealthInterval,
+		UpstreamHealthPath:     DefaultHealthPath,
+		WebSearchModel:         DefaultWebSearchModel,
+		LogLevel:               DefaultLogLevel,
+		LogFormat:              DefaultLogFormat,
+		LogOutput:              DefaultLogOutput,
+		SSEPingInterval:        DefaultSSEPingInterval,
+		WebSearchMode:          DefaultWebSearchMode,
+		InterceptMode:          DefaultInterceptMode,
+		UpstreamRetries:        DefaultUpstreamRetries,
+		UpstreamRetryBackoff:   DefaultRetryBackoff,
+		MaxIdleConnsPerHost:    DefaultMaxIdlePerHost,
+		IdleConnTimeout:        DefaultIdleConnTimeout,
+		KeepAlive:              DefaultKeepAlive,
+		CompressMinBytes:       DefaultCompressMin,
+		ShutdownDrainTimeout:   DefaultDrainTimeout,
+		InterceptPercent:       DefaultInterceptPct,
+		AlertCooldown:          DefaultAlertCooldown,
+		StatsDPrefix:           DefaultStatsDPrefix,
+		StatsDFlushInterval:    DefaultStatsDFlush,
+		GeminiRetries:          DefaultGeminiRetries,
+		GeminiAuth:             DefaultGeminiAuth,
+		VertexLocation:         DefaultVertexLocation,
+		GeminiKeyFailCooldown:  DefaultKeyFailCooldown,
+		GeminiKeySelection:     DefaultKeySelection,
+		SearchBackend:          DefaultSearchBackend,
+		SerperBaseURL:          DefaultSerperBaseURL,
+		ExaBaseURL:             DefaultExaBaseURL,
+		DuckDuckGoBaseURL:      DefaultDuckDuckGoURL,
+		GoogleCSEBaseURL:       DefaultGoogleCSEURL,
+		SearchBackendCooldown:  DefaultBackendCooldown,
+		EnrichMaxChars:         DefaultEnrichMaxChars,
+		EnrichTimeout:          DefaultEnrichTimeout,
+		SearchMaxResults:       DefaultSearchResults,
+		WebFetchMaxChars:       DefaultWebFetchChars,
+		URLContext:             true,
+		ResultDedupe:           true,
+		ResultRanking:          ResultRankingNone,
 	}
 
+	cfg.path = path
+
 	// Try to load from file
 	if path != "" {
 		data, err := os.ReadFile(path)
@@ -68,11 +484,187 @@ func LoadConfig(path string) (*Config, error) {
 	// Override with environment variables
 	loadFromEnv(cfg)
 
+	if err := loadSecretFiles(cfg); err != nil {
+		return nil, err
+	}
+
+	// A single upstream_url is a one-entry upstream list; with a list, the
+	// primary entry stands in for upstream_url
+	if len(cfg.UpstreamURLs) > 0 {
+		cfg.UpstreamURL = cfg.UpstreamURLs[0]
+	} else if cfg.UpstreamURL != "" {
+		cfg.UpstreamURLs = []string{cfg.UpstreamURL}
+	}
+
+	// Likewise gemini_api_key is a key list, comma-separated or of one key
+	if len(cfg.GeminiAPIKeys) > 0 {
+		cfg.GeminiAPIKey = cfg.GeminiAPIKeys[0]
+	} else if keys := splitList(cfg.GeminiAPIKey); len(keys) > 0 {
+		cfg.GeminiAPIKeys = keys
+		cfg.GeminiAPIKey = keys[0]
+	}
+
+	if err := resolveSecretRefs(cfg); err != nil {
+		return nil, err
+	}
+
+	// Inline credentials may be base64-encoded to survive environment variables and YAML
+	if creds := strings.TrimSpace(cfg.GeminiCredentialsJSON); creds != "" && !strings.HasPrefix(creds, "{") {
+		decoded, err := base64.StdEncoding.DecodeString(creds)
+		if err != nil {
+			return nil, fmt.Errorf("gemini_credentials_json is neither JSON nor base64: %w", err)
+		}
+		cfg.GeminiCredentialsJSON = string(decoded)
+	}
+
 	// Set GeminiAPIBaseURL to UpstreamURL if not explicitly configured
 	if cfg.GeminiAPIBaseURL == "" {
 		cfg.GeminiAPIBaseURL = cfg.UpstreamURL
 	}
 
+	for _, addr := range cfg.ListenAddresses {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen_addresses entry %q: %w", addr, err)
+		}
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if len(cfg.TLSAutocertDomains) > 0 && cfg.TLSCert != "" {
+		return nil, fmt.Errorf("tls_autocert_domains and tls_cert cannot both be set")
+	}
+	// Let's Encrypt presents no client certificate, so the ACME challenge can't pass mTLS
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return nil, fmt.Errorf("tls_client_ca requires tls_cert and tls_key")
+	}
+
+	if _, err := parseAllowedCIDRs(cfg.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+
+	if err := validateUpstreamRoutes(cfg.UpstreamRoutes); err != nil {
+		return nil, err
+	}
+
+	switch cfg.WebSearchMode {
+	case WebSearchModeReplace, WebSearchModeOrchestrate:
+	default:
+		return nil, fmt.Errorf("invalid web_search_mode %q (expected %q or %q)",
+			cfg.WebSearchMode, WebSearchModeReplace, WebSearchModeOrchestrate)
+	}
+
+	switch cfg.InterceptMode {
+	case InterceptModeAlways, InterceptModeToolChoice:
+	default:
+		return nil, fmt.Errorf("invalid intercept_mode %q (expected %q or %q)",
+			cfg.InterceptMode, InterceptModeAlways, InterceptModeToolChoice)
+	}
+
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	if cfg.LogFormat != LogFormatText && cfg.LogFormat != LogFormatJSON {
+		return nil, fmt.Errorf("invalid log_format %q (expected %q or %q)", cfg.LogFormat, LogFormatText, LogFormatJSON)
+	}
+	switch cfg.GeminiAuth {
+	case GeminiAuthAPIKey, GeminiAuthADC:
+	case GeminiAuthVertex:
+		if cfg.VertexProject == "" || cfg.VertexLocation == "" {
+			return nil, fmt.Errorf("gemini_auth %q requires vertex_project and vertex_location", GeminiAuthVertex)
+		}
+	default:
+		return nil, fmt.Errorf("invalid gemini_auth %q (expected %q, %q or %q)",
+			cfg.GeminiAuth, GeminiAuthAPIKey, GeminiAuthADC, GeminiAuthVertex)
+	}
+	switch cfg.GeminiKeySelection {
+	case KeySelectionSticky, KeySelectionLeastLoaded:
+	default:
+		return nil, fmt.Errorf("invalid gemini_key_selection %q (expected %q or %q)",
+			cfg.GeminiKeySelection, KeySelectionSticky, KeySelectionLeastLoaded)
+	}
+	if !isSearchBackend(cfg.SearchBackend) {
+		return nil, fmt.Errorf("invalid search_backend %q (expected one of %s)",
+			cfg.SearchBackend, strings.Join(searchBackendNames(), ", "))
+	}
+	chain := map[string]bool{cfg.SearchBackend: true}
+	for _, name := range cfg.SearchFallbackBackends {
+		if !isSearchBackend(name) {
+			return nil, fmt.Errorf("invalid search_fallback_backends entry %q (expected one of %s)",
+				name, strings.Join(searchBackendNames(), ", "))
+		}
+		if chain[name] {
+			return nil, fmt.Errorf("search backend %q appears twice in search_backend and search_fallback_backends", name)
+		}
+		chain[name] = true
+	}
+	fanOut := map[string]bool{cfg.SearchBackend: true}
+	for _, name := range cfg.SearchFanOutBackends {
+		if !isSearchBackend(name) {
+			return nil, fmt.Errorf("invalid search_fanout_backends entry %q (expected one of %s)",
+				name, strings.Join(searchBackendNames(), ", "))
+		}
+		if fanOut[name] {
+			return nil, fmt.Errorf("search backend %q appears twice in search_backend and search_fanout_backends", name)
+		}
+		fanOut[name] = true
+	}
+	for _, name := range cfg.ProxyAPIKeyBackends {
+		if !isSearchBackend(name) {
+			return nil, fmt.Errorf("invalid proxy_api_key_backends entry %q (expected one of %s)",
+				name, strings.Join(searchBackendNames(), ", "))
+		}
+	}
+	if cfg.SearchBackendCooldown < 0 {
+		return nil, fmt.Errorf("search_backend_cooldown must not be negative")
+	}
+	if cfg.EnrichTopResults > 0 && (cfg.EnrichMaxChars <= 0 || cfg.EnrichTimeout <= 0) {
+		return nil, fmt.Errorf("enrich_max_chars and enrich_timeout_ms must be positive")
+	}
+	if cfg.WebFetchMaxChars <= 0 {
+		return nil, fmt.Errorf("web_fetch_max_chars must be positive")
+	}
+	if cfg.SearchDynamicThreshold < 0 || cfg.SearchDynamicThreshold > 1 {
+		return nil, fmt.Errorf("search_dynamic_threshold must be between 0 and 1, got %g", cfg.SearchDynamicThreshold)
+	}
+	switch cfg.ResultRanking {
+	case ResultRankingNone, ResultRankingDomain, ResultRankingRecency:
+	default:
+		return nil, fmt.Errorf("invalid result_ranking %q (expected %q, %q or %q)",
+			cfg.ResultRanking, ResultRankingNone, ResultRankingDomain, ResultRankingRecency)
+	}
+	if cfg.ResultMaxCount < 0 {
+		return nil, fmt.Errorf("result_max_count must not be negative")
+	}
+	if err := validateResultRules(cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.LogOutput {
+	case LogOutputStderr, LogOutputSyslog, LogOutputJournald:
+	default:
+		return nil, fmt.Errorf("invalid log_output %q (expected %q, %q or %q)",
+			cfg.LogOutput, LogOutputStderr, LogOutputSyslog, LogOutputJournald)
+	}
+
+	if cfg.InterceptPercent < 0 || cfg.InterceptPercent > 100 {
+		return nil, fmt.Errorf("invalid intercept_percent %d (expected 0-100)", cfg.InterceptPercent)
+	}
+	if cfg.StatsDAddress != "" && cfg.StatsDFlushInterval <= 0 {
+		return nil, fmt.Errorf("statsd_flush_interval must be positive")
+	}
+	if cfg.DailySearchBudget < 0 || cfg.DailyTokenBudget < 0 {
+		return nil, fmt.Errorf("daily_search_budget and daily_token_budget must not be negative")
+	}
+	if cfg.GeminiKeyDailyLimit < 0 {
+		return nil, fmt.Errorf("gemini_key_daily_limit must not be negative")
+	}
+	if cfg.GeminiKeyMaxConcurrent < 0 || cfg.GeminiKeyRPM < 0 {
+		return nil, fmt.Errorf("gemini_key_max_concurrent and gemini_key_requests_per_minute must not be negative")
+	}
+	if cfg.SearchMaxResults <= 0 {
+		return nil, fmt.Errorf("search_max_results must be positive")
+	}
+
 	return cfg, nil
 }
 
@@ -86,11 +678,22 @@ func loadFromEnv(cfg *Config) {
 			cfg.ListenPort = port
 		}
 	}
+	if v := os.Getenv("LISTEN_SOCKET"); v != "" {
+		cfg.ListenSocket = v
+	}
 	if v := os.Getenv("UPSTREAM_URL"); v != "" {
 		cfg.UpstreamURL = v
+		cfg.UpstreamURLs = nil
+	}
+	if v := os.Getenv("UPSTREAM_URLS"); v != "" {
+		cfg.UpstreamURLs = splitList(v)
 	}
 	if v := os.Getenv("GEMINI_API_KEY"); v != "" {
 		cfg.GeminiAPIKey = v
+		cfg.GeminiAPIKeys = nil
+	}
+	if v := os.Getenv("GEMINI_API_KEYS"); v != "" {
+		cfg.GeminiAPIKeys = splitList(v)
 	}
 	if v := os.Getenv("WEB_SEARCH_MODEL"); v != "" {
 		cfg.WebSearchModel = v
@@ -101,4 +704,431 @@ func loadFromEnv(cfg *Config) {
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
 		cfg.LogLevel = v
 	}
+	if v := os.Getenv("SSE_PING_INTERVAL"); v != "" {
+		if interval, err := strconv.Atoi(v); err == nil {
+			cfg.SSEPingInterval = interval
+		}
+	}
+	if v := os.Getenv("WEB_SEARCH_FALLBACK"); v != "" {
+		if fallback, err := strconv.ParseBool(v); err == nil {
+			cfg.WebSearchFallback = fallback
+		}
+	}
+	if v := os.Getenv("HYBRID_TOOLS"); v != "" {
+		if hybrid, err := strconv.ParseBool(v); err == nil {
+			cfg.HybridTools = hybrid
+		}
+	}
+	if v := os.Getenv("WEB_SEARCH_MODE"); v != "" {
+		cfg.WebSearchMode = v
+	}
+	if v := os.Getenv("MAX_CONCURRENT_SEARCHES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxConcurrentSearches = n
+		}
+	}
+	if v := os.Getenv("INTERCEPT_MODE"); v != "" {
+		cfg.InterceptMode = v
+	}
+	if v := os.Getenv("TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("TLS_CLIENT_CA"); v != "" {
+		cfg.TLSClientCA = v
+	}
+	if v := os.Getenv("PROXY_API_KEYS"); v != "" {
+		cfg.ProxyAPIKeys = splitList(v)
+	}
+	if v := os.Getenv("ALLOWED_CIDRS"); v != "" {
+		cfg.AllowedCIDRs = splitList(v)
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.CORSAllowedOrigins = splitList(v)
+	}
+	if v := os.Getenv("CORS_EXPOSE_HEADERS"); v != "" {
+		cfg.CORSExposeHeaders = splitList(v)
+	}
+	if v := os.Getenv("UPSTREAM_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.UpstreamRetries = n
+		}
+	}
+	if v := os.Getenv("UPSTREAM_RETRY_BACKOFF_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.UpstreamRetryBackoff = n
+		}
+	}
+	if v := os.Getenv("OUTBOUND_PROXY"); v != "" {
+		cfg.OutboundProxy = v
+	}
+	if v := os.Getenv("CA_BUNDLE"); v != "" {
+		cfg.CABundle = v
+	}
+	if v := os.Getenv("TLS_INSECURE_SKIP_VERIFY"); v != "" {
+		if skip, err := strconv.ParseBool(v); err == nil {
+			cfg.TLSInsecureSkipVerify = skip
+		}
+	}
+	if v := os.Getenv("MAX_IDLE_CONNS_PER_HOST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxIdleConnsPerHost = n
+		}
+	}
+	if v := os.Getenv("IDLE_CONN_TIMEOUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.IdleConnTimeout = n
+		}
+	}
+	if v := os.Getenv("KEEP_ALIVE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.KeepAlive = n
+		}
+	}
+	if v := os.Getenv("DISABLE_HTTP2"); v != "" {
+		if disable, err := strconv.ParseBool(v); err == nil {
+			cfg.DisableHTTP2 = disable
+		}
+	}
+	if v := os.Getenv("COMPRESS_MIN_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.CompressMinBytes = n
+		}
+	}
+	if v := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ShutdownDrainTimeout = n
+		}
+	}
+	if v := os.Getenv("LISTEN_REUSE_PORT"); v != "" {
+		if reuse, err := strconv.ParseBool(v); err == nil {
+			cfg.ListenReusePort = reuse
+		}
+	}
+	if v := os.Getenv("LISTEN_ADDRESSES"); v != "" {
+		cfg.ListenAddresses = splitList(v)
+	}
+	if v := os.Getenv("BASE_PATH"); v != "" {
+		cfg.BasePath = v
+	}
+	if v := os.Getenv("INTERCEPT_PERCENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.InterceptPercent = n
+		}
+	}
+	if v := os.Getenv("SHADOW_MODE"); v != "" {
+		if shadow, err := strconv.ParseBool(v); err == nil {
+			cfg.ShadowMode = shadow
+		}
+	}
+	if v := os.Getenv("SHADOW_LOG_DIR"); v != "" {
+		cfg.ShadowLogDir = v
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := os.Getenv("AUDIT_LOG"); v != "" {
+		cfg.AuditLog = v
+	}
+	if v := os.Getenv("DEBUG_RECORD_DIR"); v != "" {
+		cfg.DebugRecordDir = v
+	}
+	if v := os.Getenv("ALERT_WEBHOOK_URL"); v != "" {
+		cfg.AlertWebhookURL = v
+	}
+	if v := os.Getenv("ALERT_COOLDOWN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AlertCooldown = n
+		}
+	}
+	if v := os.Getenv("SSE_CAPTURE_DIR"); v != "" {
+		cfg.SSECaptureDir = v
+	}
+	if v := os.Getenv("STARTUP_SELF_TEST"); v != "" {
+		if selfTest, err := strconv.ParseBool(v); err == nil {
+			cfg.StartupSelfTest = selfTest
+		}
+	}
+	if v := os.Getenv("USAGE_FILE"); v != "" {
+		cfg.UsageFile = v
+	}
+	if v := os.Getenv("DAILY_SEARCH_BUDGET"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.DailySearchBudget = n
+		}
+	}
+	if v := os.Getenv("DAILY_TOKEN_BUDGET"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.DailyTokenBudget = n
+		}
+	}
+	if v := os.Getenv("SLOW_REQUEST_THRESHOLD_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.SlowRequestThreshold = n
+		}
+	}
+	if v := os.Getenv("STATSD_ADDRESS"); v != "" {
+		cfg.StatsDAddress = v
+	}
+	if v, ok := os.LookupEnv("STATSD_PREFIX"); ok {
+		cfg.StatsDPrefix = v
+	}
+	if v := os.Getenv("STATSD_TAGS"); v != "" {
+		cfg.StatsDTags = splitList(v)
+	}
+	if v := os.Getenv("STATSD_FLUSH_INTERVAL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.StatsDFlushInterval = n
+		}
+	}
+	if v := os.Getenv("LOG_OUTPUT"); v != "" {
+		cfg.LogOutput = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("GEMINI_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GeminiRetries = n
+		}
+	}
+	if v := os.Getenv("GEMINI_AUTH"); v != "" {
+		cfg.GeminiAuth = v
+	}
+	if v := os.Getenv("GEMINI_CREDENTIALS_FILE"); v != "" {
+		cfg.GeminiCredentialsFile = v
+	}
+	if v := os.Getenv("VERTEX_PROJECT"); v != "" {
+		cfg.VertexProject = v
+	}
+	if v := os.Getenv("VERTEX_LOCATION"); v != "" {
+		cfg.VertexLocation = v
+	}
+	if v := os.Getenv("GEMINI_KEY_FAIL_COOLDOWN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GeminiKeyFailCooldown = n
+		}
+	}
+	if v := os.Getenv("GEMINI_API_KEY_FILE"); v != "" {
+		cfg.GeminiAPIKeyFile = v
+	}
+	if v := os.Getenv("PROXY_API_KEYS_FILE"); v != "" {
+		cfg.ProxyAPIKeysFile = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN_FILE"); v != "" {
+		cfg.AdminTokenFile = v
+	}
+	if v := os.Getenv("ALERT_WEBHOOK_URL_FILE"); v != "" {
+		cfg.AlertWebhookURLFile = v
+	}
+	if v := os.Getenv("GEMINI_HEDGE_AFTER_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GeminiHedgeAfter = n
+		}
+	}
+	if v := os.Getenv("GEMINI_KEY_MAX_CONCURRENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GeminiKeyMaxConcurrent = n
+		}
+	}
+	if v := os.Getenv("GEMINI_KEY_REQUESTS_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GeminiKeyRPM = n
+		}
+	}
+	if v := os.Getenv("GEMINI_CREDENTIALS_JSON"); v != "" {
+		cfg.GeminiCredentialsJSON = v
+	}
+	if v := os.Getenv("GEMINI_KEY_SELECTION"); v != "" {
+		cfg.GeminiKeySelection = v
+	}
+	if v := os.Getenv("GEMINI_KEY_DAILY_LIMIT"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.GeminiKeyDailyLimit = n
+		}
+	}
+	if v := os.Getenv("SEARCH_BACKEND"); v != "" {
+		cfg.SearchBackend = v
+	}
+	if v := os.Getenv("SERPER_API_KEY"); v != "" {
+		cfg.SerperAPIKey = v
+	}
+	if v := os.Getenv("SERPER_BASE_URL"); v != "" {
+		cfg.SerperBaseURL = v
+	}
+	if v := os.Getenv("SEARCH_MAX_RESULTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.SearchMaxResults = n
+		}
+	}
+	if v := os.Getenv("EXA_API_KEY"); v != "" {
+		cfg.ExaAPIKey = v
+	}
+	if v := os.Getenv("EXA_BASE_URL"); v != "" {
+		cfg.ExaBaseURL = v
+	}
+	if v := os.Getenv("DUCKDUCKGO_BASE_URL"); v != "" {
+		cfg.DuckDuckGoBaseURL = v
+	}
+	if v := os.Getenv("GOOGLE_CSE_API_KEY"); v != "" {
+		cfg.GoogleCSEAPIKey = v
+	}
+	if v := os.Getenv("GOOGLE_CSE_CX"); v != "" {
+		cfg.GoogleCSECX = v
+	}
+	if v := os.Getenv("GOOGLE_CSE_BASE_URL"); v != "" {
+		cfg.GoogleCSEBaseURL = v
+	}
+	if v := os.Getenv("SEARCH_FALLBACK_BACKENDS"); v != "" {
+		cfg.SearchFallbackBackends = splitList(v)
+	}
+	if v := os.Getenv("SEARCH_BACKEND_COOLDOWN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.SearchBackendCooldown = n
+		}
+	}
+	if v := os.Getenv("ENRICH_TOP_RESULTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.EnrichTopResults = n
+		}
+	}
+	if v := os.Getenv("ENRICH_MAX_CHARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.EnrichMaxChars = n
+		}
+	}
+	if v := os.Getenv("ENRICH_TIMEOUT_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.EnrichTimeout = n
+		}
+	}
+	if v := os.Getenv("WEB_FETCH_MAX_CHARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.WebFetchMaxChars = n
+		}
+	}
+	if v := os.Getenv("URL_CONTEXT"); v != "" {
+		if urlContext, err := strconv.ParseBool(v); err == nil {
+			cfg.URLContext = urlContext
+		}
+	}
+	if v := os.Getenv("SEARCH_DYNAMIC_THRESHOLD"); v != "" {
+		if threshold, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.SearchDynamicThreshold = threshold
+		}
+	}
+	if v := os.Getenv("RESULT_DEDUPE"); v != "" {
+		if dedupe, err := strconv.ParseBool(v); err == nil {
+			cfg.ResultDedupe = dedupe
+		}
+	}
+	if v := os.Getenv("RESULT_RANKING"); v != "" {
+		cfg.ResultRanking = v
+	}
+	if v := os.Getenv("PREFERRED_DOMAINS"); v != "" {
+		cfg.PreferredDomains = splitList(v)
+	}
+	if v := os.Getenv("DEMOTED_DOMAINS"); v != "" {
+		cfg.DemotedDomains = splitList(v)
+	}
+	if v := os.Getenv("RESULT_MAX_COUNT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ResultMaxCount = n
+		}
+	}
+	if v := os.Getenv("RESULT_BLOCKED_DOMAINS"); v != "" {
+		cfg.ResultBlockedDomains = splitList(v)
+	}
+	if v := os.Getenv("SEARCH_FANOUT_BACKENDS"); v != "" {
+		cfg.SearchFanOutBackends = splitList(v)
+	}
+	if v := os.Getenv("QUERY_REWRITE"); v != "" {
+		if rewrite, err := strconv.ParseBool(v); err == nil {
+			cfg.QueryRewrite = rewrite
+		}
+	}
+	if v := os.Getenv("FETCH_PRIVATE_ADDRESSES"); v != "" {
+		if private, err := strconv.ParseBool(v); err == nil {
+			cfg.FetchPrivateAddresses = private
+		}
+	}
+	if v := os.Getenv("TLS_AUTOCERT_DOMAINS"); v != "" {
+		cfg.TLSAutocertDomains = splitList(v)
+	}
+	if v := os.Getenv("TLS_AUTOCERT_CACHE_DIR"); v != "" {
+		cfg.TLSAutocertCacheDir = v
+	}
+	if v := os.Getenv("TLS_AUTOCERT_EMAIL"); v != "" {
+		cfg.TLSAutocertEmail = v
+	}
+}
+
+// loadSecretFiles replaces secrets with the contents of the *_file settings, so they
+// don't have to be passed through environment variables visible in ps and docker inspect
+func loadSecretFiles(cfg *Config) error {
+	if cfg.GeminiAPIKeyFile != "" {
+		keys, err := readSecretList(cfg.GeminiAPIKeyFile)
+		if err != nil {
+			return err
+		}
+		cfg.GeminiAPIKey = ""
+		cfg.GeminiAPIKeys = keys
+	}
+	if cfg.ProxyAPIKeysFile != "" {
+		keys, err := readSecretList(cfg.ProxyAPIKeysFile)
+		if err != nil {
+			return err
+		}
+		cfg.ProxyAPIKeys = keys
+	}
+	if cfg.AdminTokenFile != "" {
+		token, err := readSecret(cfg.AdminTokenFile)
+		if err != nil {
+			return err
+		}
+		cfg.AdminToken = token
+	}
+	if cfg.AlertWebhookURLFile != "" {
+		url, err := readSecret(cfg.AlertWebhookURLFile)
+		if err != nil {
+			return err
+		}
+		cfg.AlertWebhookURL = url
+	}
+	return nil
+}
+
+// readSecret reads a secret from a file, without surrounding whitespace
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// readSecretList reads secrets from a file holding one per line
+func readSecretList(path string) ([]string, error) {
+	secret, err := readSecret(path)
+	if err != nil {
+		return nil, err
+	}
+	return splitList(strings.ReplaceAll(secret, "\n", ",")), nil
+}
+
+// splitList splits a comma-separated environment value into trimmed, non-empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,35 +24,10 @@ func ConvertToClaudeNonStream(ctx context.Context, model string, geminiResp []by
 	outputTokens := getUsageField(geminiResp, "candidatesTokenCount")
 
 	// Generate IDs
-	msgID := fmt.Sprintf("msg_%s", uuid.New().String()[:24])
-	toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano())
-
-	// Build search query from webSearchQueries
-	searchQuery := ""
-	if queries := groundingMetadata.Get("webSearchQueries"); queries.IsArray() && len(queries.Array()) > 0 {
-		searchQuery = queries.Array()[0].String()
-	}
+	msgID := NewMessageID()
 
-	// Build content array
-	content := []map[string]interface{}{}
-
-	// 1. server_tool_use block
-	serverToolUse := map[string]interface{}{
-		"type":  "server_tool_use",
-		"id":    toolUseID,
-		"name":  "web_search",
-		"input": map[string]interface{}{"query": searchQuery},
-	}
-	content = append(content, serverToolUse)
-
-	// 2. web_search_tool_result block with resolved URLs
-	webSearchResults := extractWebSearchResultsWithResolve(ctx, groundingMetadata, resolver)
-	webSearchToolResult := map[string]interface{}{
-		"type":        "web_search_tool_result",
-		"tool_use_id": toolUseID,
-		"content":     webSearchResults,
-	}
-	content = append(content, webSearchToolResult)
+	// 1-2. server_tool_use and web_search_tool_result blocks with resolved URLs
+	content, webSearchResults := buildSearchToolBlocks(ctx, groundingMetadata, resolver)
 
 	// 3. Citation text blocks
 	groundingSupports := extractGroundingSupports(geminiResp)
@@ -67,6 +43,22 @@ func ConvertToClaudeNonStream(ctx context.Context, model string, geminiResp []by
 		content = append(content, textBlock)
 	}
 
+	// 5. tool_use blocks for client tools Gemini called (hybrid tool mode)
+	stopReason := "end_turn"
+	for _, call := range extractFunctionCalls(geminiResp) {
+		var input interface{} = map[string]interface{}{}
+		if args := call.Get("args"); args.IsObject() {
+			input = args.Value()
+		}
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    toolUseIDForCall(call),
+			"name":  call.Get("name").String(),
+			"input": input,
+		})
+		stopReason = "tool_use"
+	}
+
 	// Build final response
 	response := map[string]interface{}{
 		"id":            msgID,
@@ -74,7 +66,7 @@ func ConvertToClaudeNonStream(ctx context.Context, model string, geminiResp []by
 		"role":          "assistant",
 		"content":       content,
 		"model":         model,
-		"stop_reason":   "end_turn",
+		"stop_reason":   stopReason,
 		"stop_sequence": nil,
 		"usage": map[string]interface{}{
 			"input_tokens":  inputTokens,
@@ -89,6 +81,36 @@ func ConvertToClaudeNonStream(ctx context.Context, model string, geminiResp []by
 	return string(respJSON)
 }
 
+// buildSearchToolBlocks builds the server_tool_use and web_search_tool_result blocks
+// describing the search, returning them along with the resolved search results
+func buildSearchToolBlocks(ctx context.Context, groundingMetadata gjson.Result, resolver *URLResolver) ([]map[string]interface{}, []map[string]interface{}) {
+	toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano())
+
+	// Build search query from webSearchQueries
+	searchQuery := ""
+	if queries := groundingMetadata.Get("webSearchQueries"); queries.IsArray() && len(queries.Array()) > 0 {
+		searchQuery = queries.Array()[0].String()
+	}
+
+	// server_tool_use block
+	serverToolUse := map[string]interface{}{
+		"type":  "server_tool_use",
+		"id":    toolUseID,
+		"name":  "web_search",
+		"input": map[string]interface{}{"query": searchQuery},
+	}
+
+	// web_search_tool_result block with resolved URLs
+	webSearchResults := extractWebSearchResultsWithResolve(ctx, groundingMetadata, resolver)
+	webSearchToolResult := map[string]interface{}{
+		"type":        "web_search_tool_result",
+		"tool_use_id": toolUseID,
+		"content":     webSearchResults,
+	}
+
+	return []map[string]interface{}{serverToolUse, webSearchToolResult}, webSearchResults
+}
+
 // extractTextContent extracts text from Gemini response
 func extractTextContent(resp []byte) string {
 	// Try wrapped format first (response.candidates...), then top-level (candidates...)
@@ -108,6 +130,31 @@ func extractTextContent(resp []byte) string {
 	return text
 }
 
+// extractFunctionCalls extracts functionCall objects from the Gemini response parts
+func extractFunctionCalls(resp []byte) []gjson.Result {
+	parts := gjson.GetBytes(resp, "response.candidates.0.content.parts")
+	if !parts.IsArray() {
+		parts = gjson.GetBytes(resp, "candidates.0.content.parts")
+	}
+
+	var calls []gjson.Result
+	for _, part := range parts.Array() {
+		if fc := part.Get("functionCall"); fc.Exists() {
+			calls = append(calls, fc)
+		}
+	}
+	return calls
+}
+
+// toolUseIDForCall returns the Claude tool_use ID for a Gemini functionCall,
+// reusing Gemini's call ID when one is provided
+func toolUseIDForCall(call gjson.Result) string {
+	if id := call.Get("id").String(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("toolu_%s", strings.ReplaceAll(uuid.New().String(), "-", "")[:24])
+}
+
 // extractGroundingMetadata extracts grounding metadata from Gemini response
 func extractGroundingMetadata(resp []byte) gjson.Result {
 	gm := gjson.GetBytes(resp, "response.candidates.0.groundingMetadata")
@@ -128,44 +175,45 @@ func getUsageField(resp []byte, field string) int64 {
 
 // extractWebSearchResultsWithResolve extracts web search results with URL resolution
 func extractWebSearchResultsWithResolve(ctx context.Context, gm gjson.Result, resolver *URLResolver) []map[string]interface{} {
-	results := extractWebSearchResultsInternal(gm)
-
-	if resolver == nil || len(results) == 0 {
-		return results
-	}
+	results, contents := extractWebSearchResultsInternal(gm)
+
+	if resolver != nil && len(results) > 0 {
+		// Collect URLs for parallel resolution
+		urls := make([]string, len(results))
+		for i, result := range results {
+			if url, ok := result["url"].(string); ok {
+				urls[i] = url
+			}
+		}
 
-	// Collect URLs for parallel resolution
-	urls := make([]string, len(results))
-	for i, result := range results {
-		if url, ok := result["url"].(string); ok {
-			urls[i] = url
+		// Resolve URLs in parallel
+		resolvedURLs := resolver.ResolveURLs(ctx, urls)
+		for i, result := range results {
+			if resolvedURLs[i] != "" && resolvedURLs[i] != urls[i] {
+				result["url"] = resolvedURLs[i]
+			}
 		}
 	}
 
-	// Resolve URLs in parallel
-	resolvedURLs := resolver.ResolveURLs(ctx, urls)
-
-	// Update results with resolved URLs and regenerate encrypted_content
+	// Generate encrypted_content with the resolved URL (use base64 JSON like Antigravity2Api)
 	for i, result := range results {
-		if resolvedURLs[i] != "" && resolvedURLs[i] != urls[i] {
-			result["url"] = resolvedURLs[i]
-		}
-		// Regenerate encrypted_content with resolved URL (use base64 JSON like Antigravity2Api)
 		url, _ := result["url"].(string)
 		title, _ := result["title"].(string)
-		result["encrypted_content"] = generateEncryptedContent(url, title)
+		result["encrypted_content"] = generateEncryptedContent(url, title, contents[i])
 	}
 
 	return results
 }
 
-// extractWebSearchResultsInternal is the internal implementation
-func extractWebSearchResultsInternal(gm gjson.Result) []map[string]interface{} {
+// extractWebSearchResultsInternal is the internal implementation. It also returns the page
+// content of each result that search engines other than Gemini provide as web.snippet.
+func extractWebSearchResultsInternal(gm gjson.Result) ([]map[string]interface{}, []string) {
 	results := []map[string]interface{}{}
+	var contents []string
 
 	chunks := gm.Get("groundingChunks")
 	if !chunks.IsArray() {
-		return results
+		return results, contents
 	}
 
 	for _, chunk := range chunks.Array() {
@@ -179,6 +227,10 @@ func extractWebSearchResultsInternal(gm gjson.Result) []map[string]interface{} {
 			"page_age": nil,
 		}
 
+		if pageAge := web.Get("pageAge").String(); pageAge != "" {
+			result["page_age"] = pageAge
+		}
+
 		title := ""
 		url := ""
 
@@ -191,21 +243,23 @@ func extractWebSearchResultsInternal(gm gjson.Result) []map[string]interface{} {
 			result["url"] = url
 		}
 
-		// Generate encrypted_content as base64 JSON (matching Antigravity2Api format)
-		result["encrypted_content"] = generateEncryptedContent(url, title)
-
 		results = append(results, result)
+		contents = append(contents, web.Get("snippet").String())
 	}
 
-	return results
+	return results, contents
 }
 
-// generateEncryptedContent creates base64-encoded JSON for encrypted_content field
-func generateEncryptedContent(url, title string) string {
+// generateEncryptedContent creates base64-encoded JSON for encrypted_content field, with
+// the page content when the search engine returned some
+func generateEncryptedContent(url, title, content string) string {
 	payload := map[string]string{
 		"url":   url,
 		"title": title,
 	}
+	if content != "" {
+		payload["content"] = content
+	}
 	payloadJSON, _ := json.Marshal(payload)
 	return base64.StdEncoding.EncodeToString(payloadJSON)
 }
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// maxCooldown caps the cooldown taken from a server-provided retry delay
+const maxCooldown = time.Hour
+
+// geminiCooldownError is returned without contacting Gemini while every key is
+// cooling down after a quota or auth error
+type geminiCooldownError struct {
+	KeyID      string
+	RetryAfter time.Duration
+}
+
+func (e *geminiCooldownError) Error() string {
+	return fmt.Sprintf("gemini key %s and the others are cooling down or at their daily limit (retry in %s)",
+		e.KeyID, e.RetryAfter.Round(time.Second))
+}
+
+// parseRetryDelay extracts how long to wait before retrying from a Gemini error response:
+// the Retry-After header (seconds or HTTP date) or the google.rpc.RetryInfo retryDelay in
+// the body. It returns 0 when the response carries no retry information.
+func parseRetryDelay(header http.Header, body []byte) time.Duration {
+	var delay time.Duration
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			delay = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			delay = time.Until(t)
+		}
+	}
+	if delay <= 0 {
+		for _, detail := range gjson.GetBytes(body, "error.details").Array() {
+			if d, err := time.ParseDuration(detail.Get("retryDelay").String()); err == nil {
+				delay = d
+				break
+			}
+		}
+	}
+	if delay < 0 {
+		return 0
+	}
+	return min(delay, maxCooldown)
+}
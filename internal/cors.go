@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultCORSExposeHeaders are the response headers browser clients may read by default
+var defaultCORSExposeHeaders = []string{"request-id", "retry-after"}
+
+// corsOriginAllowed reports whether the origin matches one of the configured CORS origins
+func (p *Proxy) corsOriginAllowed(origin string) bool {
+	for _, allowed := range p.cfg.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCORS sets CORS response headers for allowed origins and answers preflight
+// requests. It returns true when the request was a preflight and has been handled.
+func (p *Proxy) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(p.cfg.CORSAllowedOrigins) == 0 {
+		return false
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !p.corsOriginAllowed(origin) {
+		return false
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		expose := p.cfg.CORSExposeHeaders
+		if len(expose) == 0 {
+			expose = defaultCORSExposeHeaders
+		}
+		h.Set("Access-Control-Expose-Headers", strings.Join(expose, ", "))
+		return false
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	h.Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
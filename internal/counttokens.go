@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// approxCharsPerToken is the rough character-to-token ratio used by the local estimator
+const approxCharsPerToken = 4
+
+// EstimateInputTokens approximates the input token count of a Claude messages payload
+// from the size of its system prompt, messages and tool definitions
+func EstimateInputTokens(payload []byte) int {
+	chars := 0
+	for _, field := range []string{"system", "messages", "tools"} {
+		if v := gjson.GetBytes(payload, field); v.Exists() {
+			chars += utf8.RuneCountInString(v.Raw)
+		}
+	}
+	return (chars + approxCharsPerToken - 1) / approxCharsPerToken
+}
+
+// handleCountTokens handles /v1/messages/count_tokens. Payloads declaring web_search or
+// web_fetch are forwarded upstream with the tools stripped, or estimated locally when no
+// upstream exists.
+func (p *Proxy) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	model := GetModel(body)
+	if !HasWebSearchTool(body) && !HasWebFetchTool(body) {
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
+		return
+	}
+
+	if upstream := p.upstreamFor(model); upstream != nil {
+		stripped, err := StripServerTools(body)
+		if err == nil {
+			slog.Debug("Forwarding count_tokens upstream without web_search", "path", r.URL.Path)
+			setRequestBody(r, stripped)
+			upstream.ServeHTTP(w, r)
+			return
+		}
+		slog.Warn("Failed to strip web_search tool for count_tokens, estimating locally", "error", err)
+	}
+
+	resp, _ := json.Marshal(map[string]int{"input_tokens": EstimateInputTokens(body)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
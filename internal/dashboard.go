@@ -0,0 +1,29 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// dashboardHTML is the status dashboard page. It holds no data itself; the page asks
+// for the admin token and loads /admin/dashboard/data with it.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// handleDashboard serves GET /admin/dashboard
+func (p *Proxy) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardHTML)
+}
+
+// handleDashboardData serves GET /admin/dashboard/data: the /status report plus the
+// most recent searches
+func (p *Proxy) handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	report := p.statusReport()
+	report["recent_searches"] = p.history.recent()
+	resp, _ := json.Marshal(report)
+	p.writeJSON(w, r, http.StatusOK, resp)
+}
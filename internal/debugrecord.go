@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// secretPattern matches credentials that must never be written to debug recordings:
+// Google API keys, OAuth access tokens and Anthropic-style API keys
+var secretPattern = regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}|ya29\.[0-9A-Za-z_\-]+|sk-[A-Za-z0-9_\-]{16,}`)
+
+// debugRecord writes the stages of a single intercepted request into its own directory
+type debugRecord struct {
+	dir string
+}
+
+type debugRecordKey struct{}
+
+// startDebugRecord creates the recording directory for an intercepted request when
+// debug_record_dir is configured, records the incoming payload, and attaches the
+// recorder to the request context so later stages can add to it
+func (p *Proxy) startDebugRecord(r *http.Request, requestID string, body []byte) *http.Request {
+	if p.cfg.DebugRecordDir == "" {
+		return r
+	}
+	dir := filepath.Join(p.cfg.DebugRecordDir, time.Now().UTC().Format("20060102T150405")+"_"+requestID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		slog.Error("Failed to create debug record directory", "dir", dir, "error", err)
+		return r
+	}
+	rec := &debugRecord{dir: dir}
+	rec.write("claude_request.json", body)
+	return r.WithContext(context.WithValue(r.Context(), debugRecordKey{}, rec))
+}
+
+// debugRecordFrom returns the recorder attached to ctx, or nil when recording is off
+func debugRecordFrom(ctx context.Context) *debugRecord {
+	rec, _ := ctx.Value(debugRecordKey{}).(*debugRecord)
+	return rec
+}
+
+// write stores a redacted copy of data under the given file name. It is a no-op on a nil recorder.
+func (rec *debugRecord) write(name string, data []byte) {
+	if rec == nil {
+		return
+	}
+	redacted := secretPattern.ReplaceAll(data, []byte("<redacted>"))
+	if err := os.WriteFile(filepath.Join(rec.dir, name), redacted, 0o600); err != nil {
+		slog.Error("Failed to write debug record", "file", name, "error", err)
+	}
+}
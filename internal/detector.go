@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"hash/fnv"
 	"strings"
 
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 // HasWebSearchTool checks if the request payload contains a web_search tool
@@ -14,15 +16,175 @@ func HasWebSearchTool(payload []byte) bool {
 	}
 
 	for _, tool := range tools.Array() {
-		toolType := tool.Get("type").String()
-		// Match web_search, web_search_20250305, etc.
-		if strings.HasPrefix(toolType, "web_search") {
+		if isWebSearchTool(tool) {
 			return true
 		}
 	}
 	return false
 }
 
+// isWebSearchTool checks if a single tool definition is a web_search server tool
+func isWebSearchTool(tool gjson.Result) bool {
+	// Match web_search, web_search_20250305, etc.
+	return strings.HasPrefix(tool.Get("type").String(), "web_search")
+}
+
+// HasWebFetchTool checks if the request payload contains a web_fetch tool
+func HasWebFetchTool(payload []byte) bool {
+	return webFetchTool(payload).Exists()
+}
+
+// webFetchTool returns the web_fetch tool declared in the payload, if any
+func webFetchTool(payload []byte) gjson.Result {
+	for _, tool := range gjson.GetBytes(payload, "tools").Array() {
+		if isWebFetchTool(tool) {
+			return tool
+		}
+	}
+	return gjson.Result{}
+}
+
+// isWebFetchTool checks if a single tool definition is a web_fetch server tool
+func isWebFetchTool(tool gjson.Result) bool {
+	// Match web_fetch_20250910, etc.
+	return strings.HasPrefix(tool.Get("type").String(), "web_fetch")
+}
+
+// StripServerTools removes the web_search and web_fetch server tools from the request
+// payload so it can be forwarded to an upstream that does not support them. A tool_choice
+// that targets a removed tool (or requires a tool when none remain) is dropped as well.
+func StripServerTools(payload []byte) ([]byte, error) {
+	tools := gjson.GetBytes(payload, "tools")
+	if !tools.IsArray() {
+		return payload, nil
+	}
+
+	removed := make(map[string]bool)
+	var kept []string
+	for _, tool := range tools.Array() {
+		if isWebSearchTool(tool) || isWebFetchTool(tool) {
+			removed[tool.Get("name").String()] = true
+			continue
+		}
+		kept = append(kept, tool.Raw)
+	}
+
+	var err error
+	toolChoice := gjson.GetBytes(payload, "tool_choice")
+	if len(kept) == 0 {
+		if payload, err = sjson.DeleteBytes(payload, "tools"); err != nil {
+			return nil, err
+		}
+		if toolChoice.Exists() && toolChoice.Get("type").String() != "none" {
+			return sjson.DeleteBytes(payload, "tool_choice")
+		}
+		return payload, nil
+	}
+
+	if payload, err = sjson.SetRawBytes(payload, "tools", []byte("["+strings.Join(kept, ",")+"]")); err != nil {
+		return nil, err
+	}
+	if toolChoice.Get("type").String() == "tool" && removed[toolChoice.Get("name").String()] {
+		return sjson.DeleteBytes(payload, "tool_choice")
+	}
+	return payload, nil
+}
+
+// webSearchToolNames returns the names of the web_search tools declared in the payload
+func webSearchToolNames(payload []byte) map[string]bool {
+	names := make(map[string]bool)
+	for _, tool := range gjson.GetBytes(payload, "tools").Array() {
+		if isWebSearchTool(tool) {
+			names[tool.Get("name").String()] = true
+		}
+	}
+	return names
+}
+
+// ToolChoiceForcesWebSearch checks if tool_choice forces a web_search tool, either by
+// name or by requiring a tool when web_search is the only one declared
+func ToolChoiceForcesWebSearch(payload []byte) bool {
+	names := webSearchToolNames(payload)
+	if len(names) == 0 {
+		return false
+	}
+
+	toolChoice := gjson.GetBytes(payload, "tool_choice")
+	switch toolChoice.Get("type").String() {
+	case "tool":
+		return names[toolChoice.Get("name").String()]
+	case "any":
+		return len(gjson.GetBytes(payload, "tools").Array()) == len(names)
+	}
+	return false
+}
+
+// LastAssistantRequestedWebSearch checks if the most recent assistant turn asked for a
+// web search through a tool_use or server_tool_use block naming a web_search tool
+func LastAssistantRequestedWebSearch(payload []byte) bool {
+	names := webSearchToolNames(payload)
+	messages := gjson.GetBytes(payload, "messages").Array()
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Get("role").String() != "assistant" {
+			continue
+		}
+		for _, block := range messages[i].Get("content").Array() {
+			blockType := block.Get("type").String()
+			if (blockType == "tool_use" || blockType == "server_tool_use") && names[block.Get("name").String()] {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// WebSearchMaxUses returns the max_uses limit of the declared web_search tool (0 = unlimited)
+func WebSearchMaxUses(payload []byte) int {
+	for _, tool := range gjson.GetBytes(payload, "tools").Array() {
+		if isWebSearchTool(tool) {
+			return int(tool.Get("max_uses").Int())
+		}
+	}
+	return 0
+}
+
+// CountWebFetchResults counts the web_fetch_tool_result blocks already in the conversation
+func CountWebFetchResults(payload []byte) int {
+	count := 0
+	for _, msg := range gjson.GetBytes(payload, "messages").Array() {
+		for _, block := range msg.Get("content").Array() {
+			if block.Get("type").String() == "web_fetch_tool_result" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// CountWebSearchResults counts the web_search_tool_result blocks already in the conversation
+func CountWebSearchResults(payload []byte) int {
+	count := 0
+	for _, msg := range gjson.GetBytes(payload, "messages").Array() {
+		for _, block := range msg.Get("content").Array() {
+			if block.Get("type").String() == "web_search_tool_result" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ConversationBucket maps a conversation to a stable bucket in [0, 100), derived from
+// its first message, so every turn of a conversation lands in the same bucket
+func ConversationBucket(payload []byte) int {
+	h := fnv.New32a()
+	h.Write([]byte(gjson.GetBytes(payload, "system").Raw))
+	h.Write([]byte(gjson.GetBytes(payload, "messages.0").Raw))
+	return int(h.Sum32() % 100)
+}
+
 // ExtractUserQuery extracts the last user message text for web search
 func ExtractUserQuery(payload []byte) string {
 	messages := gjson.GetBytes(payload, "messages")
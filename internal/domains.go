@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// DomainFilter restricts search results to allowed domains and excludes blocked ones,
+// mirroring the allowed_domains / blocked_domains options of Claude's web_search tool
+type DomainFilter struct {
+	Allowed []string
+	Blocked []string
+}
+
+// ExtractDomainFilter reads allowed_domains and blocked_domains from the declared web_search tool.
+// It returns nil when the tool sets neither.
+func ExtractDomainFilter(payload []byte) *DomainFilter {
+	for _, tool := range gjson.GetBytes(payload, "tools").Array() {
+		if isWebSearchTool(tool) {
+			return toolDomainFilter(tool)
+		}
+	}
+	return nil
+}
+
+// toolDomainFilter reads allowed_domains and blocked_domains from a server tool definition.
+// It returns nil when the tool sets neither.
+func toolDomainFilter(tool gjson.Result) *DomainFilter {
+	filter := &DomainFilter{}
+	for _, d := range tool.Get("allowed_domains").Array() {
+		filter.Allowed = append(filter.Allowed, normalizeDomain(d.String()))
+	}
+	for _, d := range tool.Get("blocked_domains").Array() {
+		filter.Blocked = append(filter.Blocked, normalizeDomain(d.String()))
+	}
+	if len(filter.Allowed) == 0 && len(filter.Blocked) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// normalizeDomain lowercases a domain entry and strips any scheme and leading "www."
+func normalizeDomain(d string) string {
+	d = strings.ToLower(strings.TrimSpace(d))
+	if i := strings.Index(d, "://"); i != -1 {
+		d = d[i+3:]
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(d, "/"), "www.")
+}
+
+// matchDomain checks if a URL falls under a domain entry. Subdomains match their parent
+// domain, and entries with a path ("example.com/blog") also require the path prefix.
+func matchDomain(u *url.URL, entry string) bool {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	entryHost, entryPath, _ := strings.Cut(entry, "/")
+
+	if host != entryHost && !strings.HasSuffix(host, "."+entryHost) {
+		return false
+	}
+	return entryPath == "" || strings.HasPrefix(strings.TrimPrefix(u.Path, "/"), entryPath)
+}
+
+// Allows reports whether a result URL passes the filter
+func (f *DomainFilter) Allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return len(f.Allowed) == 0
+	}
+
+	for _, entry := range f.Blocked {
+		if matchDomain(u, entry) {
+			return false
+		}
+	}
+	if len(f.Allowed) == 0 {
+		return true
+	}
+	for _, entry := range f.Allowed {
+		if matchDomain(u, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchHint returns search operators (site:/-site:) expressing the filter, for inclusion
+// in the Gemini request so the search itself targets the right domains
+func (f *DomainFilter) SearchHint() string {
+	return "Restrict the web search with these operators: " + f.Operators()
+}
+
+// Operators expresses the filter as search operators, e.g. "site:a.com OR site:b.com -site:c.com"
+func (f *DomainFilter) Operators() string {
+	var ops []string
+	for i, d := range f.Allowed {
+		if i > 0 {
+			ops = append(ops, "OR")
+		}
+		ops = append(ops, "site:"+d)
+	}
+	for _, d := range f.Blocked {
+		ops = append(ops, "-site:"+d)
+	}
+	return strings.Join(ops, " ")
+}
+
+// FilterGroundingChunks removes grounding chunks whose resolved URL fails the filter and
+// remaps the grounding support indices accordingly. Resolved URLs are cached by the
+// resolver, so the converters don't resolve them a second time.
+func FilterGroundingChunks(ctx context.Context, geminiResp []byte, filter *DomainFilter, resolver *URLResolver) []byte {
+	return filterGroundingChunks(ctx, geminiResp, resolver, func(chunk gjson.Result, url string) bool {
+		return !chunk.Get("web").Exists() || filter.Allows(url)
+	})
+}
+
+// filterGroundingChunks keeps the grounding chunks for which keep returns true, given the
+// chunk and its resolved URL, and remaps the grounding support indices accordingly
+func filterGroundingChunks(ctx context.Context, geminiResp []byte, resolver *URLResolver,
+	keep func(chunk gjson.Result, url string) bool) []byte {
+	prefix := "candidates.0.groundingMetadata"
+	if gjson.GetBytes(geminiResp, "response."+prefix).Exists() {
+		prefix = "response." + prefix
+	}
+
+	chunks := gjson.GetBytes(geminiResp, prefix+".groundingChunks").Array()
+	if len(chunks) == 0 {
+		return geminiResp
+	}
+
+	urls := chunkURLs(ctx, chunks, resolver)
+
+	// Map old chunk indices to new ones, dropping filtered chunks
+	remap := make(map[int64]int64)
+	var kept []string
+	for i, chunk := range chunks {
+		if !keep(chunk, urls[i]) {
+			continue
+		}
+		remap[int64(i)] = int64(len(kept))
+		kept = append(kept, chunk.Raw)
+	}
+	if len(kept) == len(chunks) {
+		return geminiResp
+	}
+	return setGroundingChunks(geminiResp, prefix, kept, remap)
+}
+
+// setGroundingChunks replaces the grounding chunks under the groundingMetadata at prefix
+// and points the grounding supports at the new chunks, remap mapping old chunk indices
+// to new ones. Supports left without chunks are dropped.
+func setGroundingChunks(geminiResp []byte, prefix string, kept []string, remap map[int64]int64) []byte {
+	out, _ := sjson.SetRawBytes(geminiResp, prefix+".groundingChunks", []byte("["+strings.Join(kept, ",")+"]"))
+
+	// Supports live either on the candidate or inside groundingMetadata
+	for _, supportsPath := range []string{strings.TrimSuffix(prefix, ".groundingMetadata") + ".groundingSupports", prefix + ".groundingSupports"} {
+		supports := gjson.GetBytes(out, supportsPath)
+		if !supports.IsArray() {
+			continue
+		}
+
+		var keptSupports []string
+		for _, support := range supports.Array() {
+			var indices []int64
+			seen := make(map[int64]bool)
+			for _, idx := range support.Get("groundingChunkIndices").Array() {
+				if newIdx, ok := remap[idx.Int()]; ok && !seen[newIdx] {
+					seen[newIdx] = true
+					indices = append(indices, newIdx)
+				}
+			}
+			if len(indices) == 0 {
+				continue
+			}
+			s, _ := sjson.Set(support.Raw, "groundingChunkIndices", indices)
+			keptSupports = append(keptSupports, s)
+		}
+		out, _ = sjson.SetRawBytes(out, supportsPath, []byte("["+strings.Join(keptSupports, ",")+"]"))
+	}
+
+	return out
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SearchBackendDuckDuckGo searches DuckDuckGo's HTML endpoint, which needs no credentials.
+// It is the fallback when no Gemini API key is configured.
+const SearchBackendDuckDuckGo = "duckduckgo"
+
+const duckDuckGoSearchPath = "/html/"
+
+var (
+	// duckDuckGoLink matches the title link of a result, whose href redirects to the page
+	duckDuckGoLink = regexp.MustCompile(`(?s)<a[^>]+class="result__a"[^>]+href="([^"]+)"[^>]*>(.*?)</a>`)
+	// duckDuckGoSnippet matches the snippet of a result
+	duckDuckGoSnippet = regexp.MustCompile(`(?s)class="result__snippet"[^>]*>(.*?)</a>`)
+	htmlTag           = regexp.MustCompile(`<[^>]*>`)
+)
+
+func init() {
+	RegisterSearchBackend(SearchBackendDuckDuckGo, newDuckDuckGoBackend)
+}
+
+// duckDuckGoBackend runs web searches by scraping DuckDuckGo's HTML results page
+type duckDuckGoBackend struct {
+	searchURL  string
+	maxResults int
+	httpClient *http.Client
+}
+
+// newDuckDuckGoBackend creates the DuckDuckGo backend from duckduckgo_base_url
+func newDuckDuckGoBackend(cfg *Config, transport http.RoundTripper) (SearchBackend, error) {
+	return &duckDuckGoBackend{
+		searchURL:  strings.TrimSuffix(cfg.DuckDuckGoBaseURL, "/") + duckDuckGoSearchPath,
+		maxResults: cfg.SearchMaxResults,
+		httpClient: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Name returns the name of the DuckDuckGo backend
+func (db *duckDuckGoBackend) Name() string {
+	return SearchBackendDuckDuckGo
+}
+
+// KeyID returns the backend name, since DuckDuckGo searches are anonymous
+func (db *duckDuckGoBackend) KeyID() string {
+	return SearchBackendDuckDuckGo
+}
+
+// ExecuteSearch searches DuckDuckGo for the last user message
+func (db *duckDuckGoBackend) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	query, err := searchQuery(ctx, claudePayload)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{"q": {query}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, db.searchURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "text/html")
+	body, err := doEngineRequest(ctx, db.httpClient, SearchBackendDuckDuckGo, req)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(body, []byte("anomaly-modal")) {
+		// DuckDuckGo answers too many automated searches with a challenge page
+		return nil, fmt.Errorf("duckduckgo rate limited the search, try again later")
+	}
+	return parseDuckDuckGoResults(query, body, db.maxResults).geminiResponse(), nil
+}
+
+// parseDuckDuckGoResults collects the organic results of a DuckDuckGo HTML results page,
+// skipping ads
+func parseDuckDuckGoResults(query string, body []byte, maxResults int) *SearchResults {
+	sr := &SearchResults{Query: query}
+	page := string(body)
+	links := duckDuckGoLink.FindAllStringSubmatchIndex(page, -1)
+	for i, m := range links {
+		if len(sr.Results) >= maxResults {
+			break
+		}
+		target := duckDuckGoTarget(html.UnescapeString(page[m[2]:m[3]]))
+		if target == "" {
+			continue
+		}
+
+		// The snippet follows the link, before the next result
+		end := len(page)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		snippet := ""
+		if s := duckDuckGoSnippet.FindStringSubmatch(page[m[1]:end]); s != nil {
+			snippet = htmlText(s[1])
+		}
+		sr.Results = append(sr.Results, SearchResult{
+			Title:   htmlText(page[m[4]:m[5]]),
+			URL:     target,
+			Snippet: snippet,
+		})
+	}
+	return sr
+}
+
+// duckDuckGoTarget returns the page a result link redirects to, or "" for ads
+func duckDuckGoTarget(href string) string {
+	if strings.HasPrefix(href, "//") {
+		href = "https:" + href
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if target := u.Query().Get("uddg"); target != "" {
+		href = target
+		if u, err = url.Parse(target); err != nil {
+			return ""
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || strings.HasSuffix(u.Hostname(), "duckduckgo.com") {
+		return ""
+	}
+	return href
+}
+
+// htmlText returns the text of an HTML fragment
+func htmlText(fragment string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(fragment, ""))), " ")
+}
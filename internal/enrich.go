@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// enrichMaxPageBytes caps the size of a page read for enrichment
+	enrichMaxPageBytes = 2 << 20
+	// enrichMinLineWords drops shorter lines of page text, which are mostly menus and buttons
+	enrichMinLineWords = 5
+)
+
+var (
+	// pageBoilerplate matches elements that hold no page content
+	pageBoilerplate = regexp.MustCompile(`(?is)<!--.*?-->|<script\b.*?</script>|<style\b.*?</style>|` +
+		`<noscript\b.*?</noscript>|<svg\b.*?</svg>|<nav\b.*?</nav>|<header\b.*?</header>|` +
+		`<footer\b.*?</footer>|<aside\b.*?</aside>|<form\b.*?</form>`)
+	// pageMainContent matches the element holding the main content of a page
+	pageMainContent = regexp.MustCompile(`(?is)<article\b.*</article>|<main\b.*</main>`)
+	pageBody        = regexp.MustCompile(`(?is)<body\b.*</body>`)
+	pageTitle       = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title>`)
+	// pageBlock matches the tags that break the text into lines
+	pageBlock = regexp.MustCompile(`(?i)</?(p|div|br|li|h[1-6]|tr|td|section|blockquote|pre)\b[^>]*>`)
+)
+
+// pageEnricher fetches the top results of a search and attaches the readable text of each
+// page, so the web_search_result blocks carry real passages rather than titles only
+type pageEnricher struct {
+	httpClient *http.Client
+	resolver   *URLResolver
+	topResults int
+	maxChars   int
+	timeout    time.Duration
+}
+
+// newPageEnricher creates the enrichment stage from enrich_top_results, enrich_max_chars
+// and enrich_timeout_ms, or returns nil when it is disabled. Pages are fetched with
+// transport, which should refuse internal addresses the results may point at.
+func newPageEnricher(cfg *Config, transport http.RoundTripper, resolver *URLResolver) *pageEnricher {
+	if cfg.EnrichTopResults <= 0 {
+		return nil
+	}
+	return &pageEnricher{
+		httpClient: &http.Client{Transport: transport},
+		resolver:   resolver,
+		topResults: cfg.EnrichTopResults,
+		maxChars:   cfg.EnrichMaxChars,
+		timeout:    time.Duration(cfg.EnrichTimeout) * time.Millisecond,
+	}
+}
+
+// enrich fetches the pages of the first web grounding chunks in parallel and stores their
+// text as the chunks' snippets. Pages that fail to load keep the snippet they have.
+func (pe *pageEnricher) enrich(ctx context.Context, geminiResp []byte) []byte {
+	prefix := "candidates.0.groundingMetadata.groundingChunks"
+	if gjson.GetBytes(geminiResp, "response."+prefix).Exists() {
+		prefix = "response." + prefix
+	}
+
+	var indices []int
+	var urls []string
+	for i, chunk := range gjson.GetBytes(geminiResp, prefix).Array() {
+		if len(urls) == pe.topResults {
+			break
+		}
+		if uri := chunk.Get("web.uri").String(); uri != "" {
+			indices = append(indices, i)
+			urls = append(urls, uri)
+		}
+	}
+	if len(urls) == 0 {
+		return geminiResp
+	}
+	if pe.resolver != nil {
+		urls = pe.resolver.ResolveURLs(ctx, urls)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pe.timeout)
+	defer cancel()
+	texts := make([]string, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page, err := fetchPage(ctx, pe.httpClient, url)
+			if err != nil {
+				slog.Debug("Failed to fetch result page for enrichment", "url", url, "error", err)
+				return
+			}
+			texts[i] = trimText(page.Text, pe.maxChars)
+		}()
+	}
+	wg.Wait()
+
+	for i, text := range texts {
+		if text != "" {
+			geminiResp, _ = sjson.SetBytes(geminiResp, fmt.Sprintf("%s.%d.web.snippet", prefix, indices[i]), text)
+		}
+	}
+	return geminiResp
+}
+
+// fetchedPage is the readable text of a web page
+type fetchedPage struct {
+	URL   string // after redirects
+	Title string
+	Text  string
+}
+
+// unsupportedContentError means a page is neither HTML nor plain text
+type unsupportedContentError struct {
+	MediaType string
+}
+
+func (e *unsupportedContentError) Error() string {
+	return fmt.Sprintf("unsupported content type %q", e.MediaType)
+}
+
+// fetchPage downloads a page and extracts its title and readable text
+func fetchPage(ctx context.Context, client *http.Client, url string) (*fetchedPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,text/plain;q=0.9")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" && mediaType != "text/plain" {
+		return nil, &unsupportedContentError{MediaType: mediaType}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, enrichMaxPageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &fetchedPage{URL: resp.Request.URL.String(), Text: strings.TrimSpace(string(body))}
+	if mediaType != "text/plain" {
+		if m := pageTitle.FindStringSubmatch(page.Text); m != nil {
+			page.Title = htmlText(m[1])
+		}
+		page.Text = readableText(page.Text)
+	}
+	return page, nil
+}
+
+// readableText extracts the main text of an HTML page: the article or main element when
+// there is one, without scripts, navigation and other boilerplate, one line per block
+func readableText(page string) string {
+	page = pageBoilerplate.ReplaceAllString(page, "")
+	if main := pageMainContent.FindString(page); main != "" {
+		page = main
+	} else if body := pageBody.FindString(page); body != "" {
+		page = body
+	}
+	page = pageBlock.ReplaceAllString(page, "\n")
+	page = html.UnescapeString(htmlTag.ReplaceAllString(page, ""))
+
+	var lines []string
+	for _, line := range strings.Split(page, "\n") {
+		if words := strings.Fields(line); len(words) >= enrichMinLineWords {
+			lines = append(lines, strings.Join(words, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimText shortens text to at most maxChars characters, cutting at a word boundary
+func trimText(text string, maxChars int) string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	cut := string(runes[:maxChars])
+	if i := strings.LastIndexAny(cut, " \n"); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + " …"
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Anthropic API error types
+const (
+	errTypeInvalidRequest  = "invalid_request_error"
+	errTypeAuthentication  = "authentication_error"
+	errTypePermission      = "permission_error"
+	errTypeNotFound        = "not_found_error"
+	errTypeRequestTooLarge = "request_too_large"
+	errTypeRateLimit       = "rate_limit_error"
+	errTypeAPI             = "api_error"
+	errTypeOverloaded      = "overloaded_error"
+)
+
+// errorBody builds an Anthropic error object: {"type":"error","error":{"type":...,"message":...}}
+func errorBody(errType, message string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errType,
+			"message": message,
+		},
+	})
+	return body
+}
+
+// writeError writes an Anthropic-formatted JSON error response
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(errorBody(errType, message))
+}
+
+// errorEvent builds an Anthropic error SSE event for failures after a stream has started
+func errorEvent(errType, message string) string {
+	return "event: error\ndata: " + string(errorBody(errType, message)) + "\n\n"
+}
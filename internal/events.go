@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// eventHistory is the number of recent events replayed to a new subscriber
+	eventHistory = 200
+	// eventSubscriberBuffer is how many events a slow subscriber may fall behind before events are dropped
+	eventSubscriberBuffer = 256
+)
+
+// proxyEvent is a log record published on the admin event stream
+type proxyEvent struct {
+	Time    string         `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// eventHub fans out proxy events to admin stream subscribers and keeps the most recent ones
+type eventHub struct {
+	mu          sync.Mutex
+	recent      []proxyEvent
+	subscribers map[chan proxyEvent]struct{}
+}
+
+// adminEvents receives every log record at info level and above, so interceptions,
+// upstream failovers and errors can be watched live
+var adminEvents = &eventHub{subscribers: make(map[chan proxyEvent]struct{})}
+
+// publish records an event and sends it to every subscriber that keeps up
+func (h *eventHub) publish(e proxyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.recent) == eventHistory {
+		h.recent = append(h.recent[:0], h.recent[1:]...)
+	}
+	h.recent = append(h.recent, e)
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the recent events and a channel receiving new ones; call the
+// returned function to unsubscribe
+func (h *eventHub) subscribe() ([]proxyEvent, <-chan proxyEvent, func()) {
+	ch := make(chan proxyEvent, eventSubscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[ch] = struct{}{}
+	recent := append([]proxyEvent(nil), h.recent...)
+	return recent, ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// eventHandler passes records to the configured log handler and publishes those at
+// info level and above on the event hub, independently of the configured log level
+type eventHandler struct {
+	base slog.Handler
+	hub  *eventHub
+}
+
+func (h *eventHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.base.Enabled(ctx, level)
+}
+
+func (h *eventHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelInfo {
+		e := proxyEvent{Time: r.Time.UTC().Format(time.RFC3339Nano), Level: r.Level.String(), Message: r.Message}
+		r.Attrs(func(a slog.Attr) bool {
+			if e.Attrs == nil {
+				e.Attrs = make(map[string]any)
+			}
+			e.Attrs[a.Key] = eventValue(a.Value.Resolve())
+			return true
+		})
+		h.hub.publish(e)
+	}
+	if !h.base.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.base.Handle(ctx, r)
+}
+
+// eventValue converts a log attribute value to its JSON representation in an event
+func eventValue(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindString, slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool:
+		return v.Any()
+	default:
+		// Errors, durations, times and other values are reported as their log text
+		return v.String()
+	}
+}
+
+func (h *eventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventHandler{base: h.base.WithAttrs(attrs), hub: h.hub}
+}
+
+func (h *eventHandler) WithGroup(name string) slog.Handler {
+	return &eventHandler{base: h.base.WithGroup(name), hub: h.hub}
+}
+
+// handleAdminEvents serves GET /admin/events: an SSE stream of the recent proxy events
+// followed by new ones as they happen
+func (p *Proxy) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	recent, events, unsubscribe := adminEvents.subscribe()
+	defer unsubscribe()
+
+	sw := newSSEWriter(w)
+	stopPing := sw.StartPing(time.Duration(p.cfg.SSEPingInterval) * time.Second)
+	defer stopPing()
+
+	send := func(e proxyEvent) {
+		data, _ := json.Marshal(e)
+		sw.Send("event: log\ndata: " + string(data) + "\n\n")
+	}
+	for _, e := range recent {
+		send(e)
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			send(e)
+		}
+	}
+}
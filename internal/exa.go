@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// SearchBackendExa runs semantic searches with Exa
+const SearchBackendExa = "exa"
+
+const (
+	exaSearchPath = "/search"
+	// exaMaxCharacters caps the page text returned per result when it has no highlights
+	exaMaxCharacters = 1000
+	// exaHighlightSentences is the number of sentences per highlight
+	exaHighlightSentences = 3
+)
+
+func init() {
+	RegisterSearchBackend(SearchBackendExa, newExaBackend)
+}
+
+// exaBackend runs web searches with Exa, returning the most relevant passages of each
+// page along with the results
+type exaBackend struct {
+	searchURL  string
+	apiKey     string
+	maxResults int
+	httpClient *http.Client
+}
+
+// newExaBackend creates the Exa backend from exa_api_key and exa_base_url
+func newExaBackend(cfg *Config, transport http.RoundTripper) (SearchBackend, error) {
+	if cfg.ExaAPIKey == "" {
+		return nil, fmt.Errorf("exa_api_key is required with search_backend %q", SearchBackendExa)
+	}
+	return &exaBackend{
+		searchURL:  strings.TrimSuffix(cfg.ExaBaseURL, "/") + exaSearchPath,
+		apiKey:     cfg.ExaAPIKey,
+		maxResults: cfg.SearchMaxResults,
+		httpClient: &http.Client{Timeout: 60 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Name returns the name of the Exa backend
+func (eb *exaBackend) Name() string {
+	return SearchBackendExa
+}
+
+// KeyID returns a short, non-reversible identifier of the Exa API key
+func (eb *exaBackend) KeyID() string {
+	return apiKeyID(eb.apiKey)
+}
+
+// ExecuteSearch searches with Exa for the last user message. allowed_domains and
+// blocked_domains map to Exa's domain filters rather than site: operators.
+func (eb *exaBackend) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	query := userQuery(ctx, claudePayload)
+	if query == "" {
+		return nil, fmt.Errorf("no messages found in payload")
+	}
+	search := map[string]interface{}{
+		"query":      query,
+		"numResults": eb.maxResults,
+		"contents": map[string]interface{}{
+			"text":       map[string]int{"maxCharacters": exaMaxCharacters},
+			"highlights": map[string]int{"numSentences": exaHighlightSentences},
+		},
+	}
+	if filter := ExtractDomainFilter(claudePayload); filter != nil {
+		if len(filter.Allowed) > 0 {
+			search["includeDomains"] = filter.Allowed
+		}
+		if len(filter.Blocked) > 0 {
+			search["excludeDomains"] = filter.Blocked
+		}
+	}
+	payload, _ := json.Marshal(search)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, eb.searchURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", eb.apiKey)
+	body, err := doEngineRequest(ctx, eb.httpClient, SearchBackendExa, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseExaResults(query, body).geminiResponse(), nil
+}
+
+// parseExaResults collects the results with their highlights, or the start of the page
+// text when there are none, as snippets
+func parseExaResults(query string, body []byte) *SearchResults {
+	sr := &SearchResults{Query: query}
+	for _, r := range gjson.GetBytes(body, "results").Array() {
+		var highlights []string
+		for _, h := range r.Get("highlights").Array() {
+			highlights = append(highlights, strings.TrimSpace(h.String()))
+		}
+		snippet := strings.Join(highlights, " … ")
+		if snippet == "" {
+			snippet = strings.TrimSpace(r.Get("text").String())
+		}
+		sr.Results = append(sr.Results, SearchResult{
+			Title:   r.Get("title").String(),
+			URL:     r.Get("url").String(),
+			Snippet: snippet,
+			PageAge: pageAge(r.Get("publishedDate").String()),
+		})
+	}
+	return sr
+}
+
+// pageAge formats a publication timestamp the way web_search_result page_age shows it,
+// e.g. "June 1, 2025", or returns "" when it can't be parsed
+func pageAge(published string) string {
+	t, err := time.Parse(time.RFC3339, published)
+	if err != nil {
+		return ""
+	}
+	return t.Format("January 2, 2006")
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// fanOutSearch runs the search with backend and the search_fanout_backends concurrently
+// and merges their results into one response. The first backend to succeed, in that
+// order, provides the answer; the others add their queries and the results it lacks.
+// The search only fails when every backend does.
+func (p *Proxy) fanOutSearch(ctx context.Context, backend SearchBackend, claudePayload []byte) ([]byte, error) {
+	backends := []SearchBackend{backend}
+	for _, b := range p.fanOut {
+		if b.Name() != backend.Name() {
+			backends = append(backends, b)
+		}
+	}
+
+	resps := make([][]byte, len(backends))
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resps[i], errs[i] = b.ExecuteSearch(ctx, claudePayload)
+		}()
+	}
+	wg.Wait()
+
+	var merged []byte
+	for i, resp := range resps {
+		if errs[i] != nil {
+			continue
+		}
+		if merged == nil {
+			merged = resp
+		} else {
+			merged = mergeSearchResponses(ctx, merged, resp, p.urlResolver)
+		}
+	}
+
+	// When every backend fails, the search fails with the error of backend, reported by
+	// the caller; otherwise each failure is reported here
+	for i, err := range errs {
+		if err != nil && (i > 0 || merged != nil) {
+			slog.Warn("Fan-out search backend failed", "backend", backends[i].Name(), "error", err)
+			p.metrics.Inc("search.backends." + backends[i].Name() + ".failures")
+		}
+	}
+	if merged == nil {
+		return nil, errs[0]
+	}
+	return merged, nil
+}
+
+// mergeSearchResponses adds the search queries and grounding chunks of extra to base,
+// leaving out the chunks whose URL base already has. URLs are compared once resolved, so
+// a Gemini redirect and the page it points to count as the same result.
+func mergeSearchResponses(ctx context.Context, base, extra []byte, resolver *URLResolver) []byte {
+	basePrefix := "candidates.0.groundingMetadata"
+	if gjson.GetBytes(base, "response."+basePrefix).Exists() {
+		basePrefix = "response." + basePrefix
+	}
+	extraPrefix := "candidates.0.groundingMetadata"
+	if gjson.GetBytes(extra, "response."+extraPrefix).Exists() {
+		extraPrefix = "response." + extraPrefix
+	}
+
+	queries := make(map[string]bool)
+	for _, q := range gjson.GetBytes(base, basePrefix+".webSearchQueries").Array() {
+		queries[q.String()] = true
+	}
+	for _, q := range gjson.GetBytes(extra, extraPrefix+".webSearchQueries").Array() {
+		if !queries[q.String()] {
+			queries[q.String()] = true
+			base, _ = sjson.SetBytes(base, basePrefix+".webSearchQueries.-1", q.String())
+		}
+	}
+
+	urls := make(map[string]bool)
+	for _, u := range chunkURLs(ctx, gjson.GetBytes(base, basePrefix+".groundingChunks").Array(), resolver) {
+		urls[canonicalURL(u)] = true
+	}
+	extraChunks := gjson.GetBytes(extra, extraPrefix+".groundingChunks").Array()
+	for i, u := range chunkURLs(ctx, extraChunks, resolver) {
+		canonical := canonicalURL(u)
+		if !urls[canonical] {
+			urls[canonical] = true
+			base, _ = sjson.SetRawBytes(base, basePrefix+".groundingChunks.-1", []byte(extraChunks[i].Raw))
+		}
+	}
+	return base
+}
+
+// chunkURLs returns the URLs of grounding chunks, resolved when resolver is set
+func chunkURLs(ctx context.Context, chunks []gjson.Result, resolver *URLResolver) []string {
+	urls := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		urls[i] = chunk.Get("web.uri").String()
+	}
+	if resolver != nil {
+		urls = resolver.ResolveURLs(ctx, urls)
+	}
+	return urls
+}
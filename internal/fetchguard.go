@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// nonPublicNets are the ranges page fetches must not reach besides loopback, private,
+// link-local, multicast and unspecified addresses: shared address space (carrier-grade
+// NAT), "this network", IETF protocol assignments and benchmarking
+var nonPublicNets = mustParseCIDRs("100.64.0.0/10", "0.0.0.0/8", "192.0.0.0/24", "198.18.0.0/15")
+
+// blockedAddressError means a page fetch was refused because the host resolves to an
+// address that isn't publicly routable
+type blockedAddressError struct {
+	Host string
+	IP   net.IP
+}
+
+func (e *blockedAddressError) Error() string {
+	return fmt.Sprintf("%s resolves to %s, which is not a public address", e.Host, e.IP)
+}
+
+// isPublicIP reports whether ip is publicly routable
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPageFetchTransport builds the transport fetching the pages clients and search results
+// point at, for web_fetch and enrichment. Unless fetch_private_addresses is set, it refuses
+// to connect to addresses that aren't publicly routable: the check runs on every address
+// dialed, so redirects and DNS answers changing between lookups are covered. Requests sent
+// through an outbound proxy have their host resolved and checked before they are handed
+// to the proxy, which is the only private address they may connect to.
+func NewPageFetchTransport(cfg *Config, outbound *http.Transport) *http.Transport {
+	transport := outbound.Clone()
+	if cfg.FetchPrivateAddresses {
+		return transport
+	}
+
+	var proxies sync.Map // addresses of the outbound proxies in use, dialed without the check
+	proxyFor := transport.Proxy
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if proxyFor == nil {
+			return nil, nil
+		}
+		proxyURL, err := proxyFor(req)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		if err := checkPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		proxies.Store(proxyAddress(proxyURL), true)
+		return proxyURL, nil
+	}
+
+	guarded := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return &blockedAddressError{Host: host, IP: ip}
+			}
+			return nil
+		},
+	}
+	direct := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := proxies.Load(address); ok {
+			return direct(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+	return transport
+}
+
+// checkPublicHost resolves host and fails with a blockedAddressError unless every address
+// it resolves to is public
+func checkPublicHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return &blockedAddressError{Host: host, IP: ip}
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return &blockedAddressError{Host: host, IP: addr.IP}
+		}
+	}
+	return nil
+}
+
+// proxyAddress returns the host:port the transport dials to reach a proxy
+func proxyAddress(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		switch proxyURL.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// mustParseCIDRs parses CIDR literals, panicking on invalid ones
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
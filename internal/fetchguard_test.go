@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fe80::1", false},
+		{"fd00::1", false}, // IPv6 unique local
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"198.18.0.1", false},
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+// pageFetchClient returns a client fetching through the page fetch transport built from
+// cfg, with outbound requests sent through proxyURL when it is set
+func pageFetchClient(t *testing.T, cfg *Config, proxyURL string) *http.Client {
+	t.Helper()
+	outbound, err := NewOutboundTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbound.Proxy = nil
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outbound.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: NewPageFetchTransport(cfg, outbound)}
+}
+
+func TestPageFetchTransportRefusesNonPublicAddresses(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer internal.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(internal.URL, "http://"))
+
+	client := pageFetchClient(t, &Config{}, "")
+	for _, target := range []string{
+		"http://127.0.0.1:" + port,
+		"http://localhost:" + port,
+		"http://[::ffff:127.0.0.1]:" + port,
+		"http://10.0.0.1/",
+		"http://192.168.0.1/",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		resp, err := client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("GET %s succeeded, want it refused", target)
+			continue
+		}
+		var blocked *blockedAddressError
+		if !errors.As(err, &blocked) {
+			t.Errorf("GET %s failed with %v, want a blockedAddressError", target, err)
+		}
+	}
+}
+
+func TestPageFetchTransportAllowsPrivateAddressesWhenConfigured(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer internal.Close()
+
+	client := pageFetchClient(t, &Config{FetchPrivateAddresses: true}, "")
+	resp, err := client.Get(internal.URL)
+	if err != nil {
+		t.Fatalf("GET with fetch_private_addresses: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPageFetchTransportThroughProxy(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("internal server reached through a redirect: %s", r.URL)
+	}))
+	defer internal.Close()
+
+	// A forward proxy on loopback standing in for the internet: the public page
+	// redirects to the internal server
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, internal.URL+"/secret", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "public page")
+	}))
+	defer proxy.Close()
+
+	client := pageFetchClient(t, &Config{}, proxy.URL)
+
+	// The proxy itself is on a loopback address and must still be reachable
+	resp, err := client.Get("http://203.0.113.10/page")
+	if err != nil {
+		t.Fatalf("GET through the outbound proxy: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "public page" {
+		t.Fatalf("body = %q, want the page served by the proxy", body)
+	}
+
+	resp, err = client.Get("http://203.0.113.10/redirect")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("redirect to 127.0.0.1 was followed, want it refused")
+	}
+	var blocked *blockedAddressError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("redirect failed with %v, want a blockedAddressError", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != 2 {
+		t.Errorf("proxy saw %v, want the two public requests only", proxied)
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -19,52 +19,217 @@ import (
 
 // GeminiClient handles web search requests via Gemini's googleSearch
 type GeminiClient struct {
-	apiBaseURL string
-	apiKey     string
-	model      string
-	httpClient *http.Client
-	debug      bool
+	generateURL  string
+	keys         *keyPool
+	failCooldown time.Duration // cooldown of a key rejected by Gemini
+	hedgeAfter   time.Duration // latency after which a second key is tried in parallel
+	httpClient   *http.Client
+	hybridTools  bool
+	urlContext   bool    // add the urlContext tool when the user message links to pages
+	threshold    float64 // dynamic retrieval threshold, 0 to always search
+	headers      HeaderRules
+	retries      int
+	tokens       *adcTokenSource // nil when authenticating with API keys
+
+	// onAttempt, when set, is called with the outcome of every request made with a key
+	onAttempt func(keyID string, geminiResp []byte, err error)
 }
 
 const (
 	geminiAPIGeneratePath = "/v1beta/models/%s:generateContent"
+	vertexGeneratePath    = "/v1/projects/%s/locations/%s/publishers/google/models/%s:generateContent"
 	userAgent             = "cpa-websearch-proxy/1.0"
+	geminiRetryBackoff    = 500 * time.Millisecond
 )
 
-// NewGeminiClient creates a new Gemini client for web search
-func NewGeminiClient(cfg *Config) *GeminiClient {
-	return &GeminiClient{
-		apiBaseURL: strings.TrimSuffix(cfg.GeminiAPIBaseURL, "/"),
-		apiKey:     cfg.GeminiAPIKey,
-		model:      cfg.WebSearchModel,
-		httpClient: &http.Client{Timeout: 120 * time.Second},
-		debug:      cfg.LogLevel == "debug",
+// NewGeminiClient creates a new Gemini client for web search. Depending on gemini_auth it
+// calls the Gemini API with the API key or Application Default Credentials, or Vertex AI
+// with Application Default Credentials.
+func NewGeminiClient(cfg *Config, transport http.RoundTripper) (*GeminiClient, error) {
+	gc := &GeminiClient{
+		generateURL:  strings.TrimSuffix(cfg.GeminiAPIBaseURL, "/") + fmt.Sprintf(geminiAPIGeneratePath, cfg.WebSearchModel),
+		keys:         newKeyPool(cfg.GeminiAPIKeys, cfg.GeminiKeyLabels),
+		failCooldown: time.Duration(cfg.GeminiKeyFailCooldown) * time.Second,
+		hedgeAfter:   time.Duration(cfg.GeminiHedgeAfter) * time.Millisecond,
+		httpClient:   &http.Client{Timeout: 120 * time.Second, Transport: transport},
+		hybridTools:  cfg.HybridTools,
+		urlContext:   cfg.URLContext,
+		threshold:    cfg.SearchDynamicThreshold,
+		headers:      cfg.GeminiHeaders,
+		retries:      cfg.GeminiRetries,
 	}
+	if cfg.GeminiAuth == GeminiAuthVertex {
+		gc.generateURL = vertexEndpoint(cfg.VertexLocation) +
+			fmt.Sprintf(vertexGeneratePath, cfg.VertexProject, cfg.VertexLocation, cfg.WebSearchModel)
+	}
+	if cfg.GeminiAuth == GeminiAuthADC || cfg.GeminiAuth == GeminiAuthVertex {
+		tokens, err := newADCTokenSource(cfg.GeminiCredentialsFile, cfg.GeminiCredentialsJSON, transport)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Using Application Default Credentials for Gemini", "source", tokens.source, "credentials", tokens.ID())
+		gc.tokens = tokens
+		gc.keys = newCredentialsPool(tokens.ID(), cfg.GeminiKeyLabels)
+	}
+	gc.keys.maxInFlight = cfg.GeminiKeyMaxConcurrent
+	gc.keys.perMinute = cfg.GeminiKeyRPM
+	gc.keys.leastLoaded = cfg.GeminiKeySelection == KeySelectionLeastLoaded
+	return gc, nil
+}
+
+// Name returns the name of the Gemini search backend
+func (gc *GeminiClient) Name() string {
+	return SearchBackendGemini
 }
 
-// ExecuteWebSearch performs a web search using Gemini's googleSearch tool
-func (gc *GeminiClient) ExecuteWebSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+// ExecuteSearch performs a web search using Gemini's googleSearch tool. A key rejected
+// by Gemini or out of quota is cooled down and the search is retried with the next key.
+func (gc *GeminiClient) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
 	if len(claudePayload) == 0 {
 		return nil, fmt.Errorf("empty payload")
 	}
+	if gc.keys.size() == 0 {
+		return nil, fmt.Errorf("no gemini api key configured")
+	}
+
+	var lastErr error
+	for tried := 0; tried < gc.keys.size(); tried++ {
+		k, wait, err := gc.keys.acquire(ctx, keyLabelFrom(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			err := &QuotaError{Err: &geminiCooldownError{KeyID: gc.KeyID(), RetryAfter: wait}, RetryAfter: wait}
+			gc.reportAttempt(gc.KeyID(), nil, err)
+			return nil, err
+		}
+
+		resp, err := gc.executeHedged(ctx, claudePayload, k)
+		if !isKeyError(err) {
+			return resp, err
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// attempt performs the web search request with key k, reports the outcome and takes the
+// key out of the rotation when Gemini rejects it or reports it out of quota
+func (gc *GeminiClient) attempt(ctx context.Context, claudePayload []byte, k *geminiKey) ([]byte, error) {
+	defer gc.keys.release(k)
+	resp, err := gc.executeWithRetries(ctx, claudePayload, k)
+	if context.Cause(ctx) == errHedgeLost {
+		// Cancelled because a hedged request with another key returned first
+		return resp, err
+	}
+	gc.reportAttempt(k.id, resp, err)
+	gc.keys.observe(k, isKeyError(err) || errors.As(err, new(*TransientError)))
+
+	var revokedErr *revokedCredentialsError
+	var authErr *AuthError
+	var quotaErr *QuotaError
+	switch {
+	case errors.As(err, &revokedErr):
+		gc.disableRevoked(revokedErr, err)
+	case errors.As(err, &authErr):
+		slog.Warn("Gemini rejected key, cooling it down", "key", k.id, "cooldown", gc.failCooldown, "error", err)
+		gc.keys.coolDown(k, gc.failCooldown)
+	case errors.As(err, &quotaErr) && quotaErr.RetryAfter > 0:
+		// Hold off exactly as long as Gemini asks
+		slog.Warn("Gemini quota exceeded, cooling down key", "key", k.id, "retry_after", quotaErr.RetryAfter)
+		gc.keys.coolDown(k, quotaErr.RetryAfter)
+	case errors.As(err, &quotaErr):
+		// Without a delay the key stays available, behind the others
+		slog.Warn("Gemini quota exceeded, rotating key", "key", k.id)
+		gc.keys.rotate(k)
+	}
+	return resp, err
+}
+
+// verifyCredentials fetches an access token for OAuth credentials at startup. Revoked or
+// expired credentials are taken out of the rotation so that searches fail at once with a
+// clear reason; other failures are left to the first search.
+func (gc *GeminiClient) verifyCredentials(ctx context.Context) {
+	if gc.tokens == nil {
+		return
+	}
+	_, err := gc.tokens.Token(ctx)
+	var revokedErr *revokedCredentialsError
+	switch {
+	case errors.As(err, &revokedErr):
+		gc.disableRevoked(revokedErr, err)
+	case err != nil:
+		slog.Warn("Could not verify Gemini credentials at startup", "error", err)
+	}
+}
+
+// disableRevoked takes revoked credentials out of the rotation until they are reset
+func (gc *GeminiClient) disableRevoked(revokedErr *revokedCredentialsError, err error) {
+	slog.Error("Gemini credentials were revoked or have expired; web search is disabled until they are replaced",
+		"credentials", revokedErr.Source, "account", revokedErr.Account, "reason", revokedErr.Description,
+		"hint", revokedErr.Hint())
+	for _, k := range gc.keys.all() {
+		gc.keys.disable(k, err)
+	}
+}
+
+// isKeyError reports whether err is specific to the key used, so another key may succeed
+func isKeyError(err error) bool {
+	return errors.As(err, new(*AuthError)) || errors.As(err, new(*QuotaError))
+}
 
-	return gc.executeRequest(ctx, claudePayload)
+// reportAttempt passes the outcome of a request made with a key to onAttempt
+func (gc *GeminiClient) reportAttempt(keyID string, geminiResp []byte, err error) {
+	if gc.onAttempt != nil {
+		gc.onAttempt(keyID, geminiResp, err)
+	}
+}
+
+// executeWithRetries performs the web search request with key k, retrying transient errors
+func (gc *GeminiClient) executeWithRetries(ctx context.Context, claudePayload []byte, k *geminiKey) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := gc.executeRequest(ctx, claudePayload, k)
+		var transientErr *TransientError
+		if !errors.As(err, &transientErr) || attempt >= gc.retries {
+			return resp, err
+		}
+
+		backoff := geminiRetryBackoff * time.Duration(attempt+1)
+		slog.Warn("Gemini request failed, retrying", "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+	}
 }
 
 // executeRequest performs the web search request
-func (gc *GeminiClient) executeRequest(ctx context.Context, claudePayload []byte) ([]byte, error) {
-	reqURL := gc.apiBaseURL + fmt.Sprintf(geminiAPIGeneratePath, gc.model) + "?key=" + gc.apiKey
+func (gc *GeminiClient) executeRequest(ctx context.Context, claudePayload []byte, k *geminiKey) ([]byte, error) {
+	reqURL := gc.generateURL
 
 	// Build request payload
-	payload, err := gc.buildRequest(claudePayload)
+	record := "gemini"
+	var payload string
+	var err error
+	if rewritingQueries(ctx) {
+		record = "gemini_rewrite"
+		payload, err = gc.buildRewriteRequest(claudePayload)
+	} else {
+		payload, err = gc.buildRequest(ctx, claudePayload)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to build request: %w", err)
 	}
 
+	debugRecordFrom(ctx).write(record+"_request.json", []byte(payload))
+
 	// Debug: log request details
-	if gc.debug {
-		log.Printf("[DEBUG] Gemini Request URL: %s", gc.sanitizeURL(reqURL))
-		log.Printf("[DEBUG] Gemini Request Summary: %s", summarizeGeminiRequest(payload))
+	if debugEnabled() {
+		slog.Debug("Gemini request", "url", reqURL, "summary", summarizeGeminiRequest(payload))
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader([]byte(payload)))
@@ -75,47 +240,83 @@ func (gc *GeminiClient) executeRequest(ctx context.Context, claudePayload []byte
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Accept", "application/json")
-
-	if gc.debug {
-		log.Printf("[DEBUG] Request Headers: Content-Type=%s, User-Agent=%s (API key in URL)",
-			"application/json", userAgent)
+	if err := gc.authorize(ctx, req, k); err != nil {
+		return nil, err
 	}
+	gc.headers.Apply(req.Header)
+
+	slog.Debug("Gemini request headers", "content_type", "application/json", "user_agent", userAgent)
 
 	resp, err := gc.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("gemini request failed: %w", err)
+		return nil, classifyGeminiTransportError(ctx, fmt.Errorf("gemini request failed: %w", err))
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readResponseBody(resp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read gemini response: %w", err)
 	}
 
+	debugRecordFrom(ctx).write(record+"_response.json", body)
+
 	// Debug: log response
-	if gc.debug {
-		log.Printf("[DEBUG] Gemini Response Status: %d", resp.StatusCode)
-		log.Printf("[DEBUG] Gemini Response Summary: %s", summarizeGeminiResponse(body))
+	if debugEnabled() {
+		slog.Debug("Gemini response", "status", resp.StatusCode, "summary", summarizeGeminiResponse(body))
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("gemini returned status %d (response_bytes=%d, response_sha256=%s)",
-			resp.StatusCode, len(body), sha256Hex(body))
+		return nil, classifyGeminiStatus(resp, body)
+	}
+
+	return mergeURLContextMetadata(body), nil
+}
+
+// vertexEndpoint returns the Vertex AI API endpoint serving a location
+func vertexEndpoint(location string) string {
+	if location == "global" {
+		return "https://aiplatform.googleapis.com"
 	}
+	return "https://" + location + "-aiplatform.googleapis.com"
+}
 
-	return body, nil
+// KeyID returns a short, non-reversible identifier of the current key, for audit records
+func (gc *GeminiClient) KeyID() string {
+	return gc.keys.currentID()
 }
 
-// sanitizeURL removes API key from URL for logging
-func (gc *GeminiClient) sanitizeURL(url string) string {
-	if idx := strings.Index(url, "?key="); idx != -1 {
-		return url[:idx] + "?key=<redacted>"
+// SetAPIKeys replaces the API key pool and key labels for subsequent requests. Keys that
+// remain in the pool keep their cooldown. It has no effect with Application Default Credentials.
+func (gc *GeminiClient) SetAPIKeys(keys []string, labels map[string][]string) {
+	if gc.tokens == nil {
+		gc.keys.set(keys, labels)
 	}
-	return url
+}
+
+// clearCooldown lets requests with every key through again immediately
+func (gc *GeminiClient) clearCooldown() {
+	gc.keys.reset()
+}
+
+// authorize authenticates a request with key k, or with an access token when using
+// Application Default Credentials. The key goes in a header rather than the URL, which
+// Go's HTTP client repeats in the errors it returns.
+func (gc *GeminiClient) authorize(ctx context.Context, req *http.Request, k *geminiKey) error {
+	if gc.tokens == nil {
+		req.Header.Set("x-goog-api-key", k.key)
+		return nil
+	}
+	token, err := gc.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	gc.tokens.applyHeaders(req.Header)
+	return nil
 }
 
 // buildRequest constructs the request payload for Gemini web search
-func (gc *GeminiClient) buildRequest(claudePayload []byte) (string, error) {
+func (gc *GeminiClient) buildRequest(ctx context.Context, claudePayload []byte) (string, error) {
 	// Transform Claude messages to Gemini contents format
 	contents, err := TransformMessages(claudePayload)
 	if err != nil {
@@ -138,6 +339,24 @@ func (gc *GeminiClient) buildRequest(claudePayload []byte) (string, error) {
 		}
 	}
 
+	// Express allowed_domains / blocked_domains as search operators on the latest user turn,
+	// after the queries the rewriting step chose
+	var hints []GeminiPart
+	if queries := searchQueriesFrom(ctx); len(queries) > 0 {
+		hints = append(hints, GeminiPart{Text: queriesHint(queries)})
+	}
+	if filter := ExtractDomainFilter(claudePayload); filter != nil {
+		hints = append(hints, GeminiPart{Text: filter.SearchHint()})
+	}
+	if len(hints) > 0 {
+		for i := len(contents) - 1; i >= 0; i-- {
+			if contents[i].Role == "user" {
+				contents[i].Parts = append(contents[i].Parts, hints...)
+				break
+			}
+		}
+	}
+
 	// Convert contents to JSON
 	contentsJSON, err := json.Marshal(contents)
 	if err != nil {
@@ -150,6 +369,29 @@ func (gc *GeminiClient) buildRequest(claudePayload []byte) (string, error) {
 	// Set contents
 	req, _ = sjson.SetRaw(req, "contents", string(contentsJSON))
 
+	// Dynamic retrieval: the models supporting it only search when the query needs it
+	if gc.threshold > 0 {
+		req, _ = sjson.SetRaw(req, "tools.0", fmt.Sprintf(
+			`{"googleSearchRetrieval":{"dynamicRetrievalConfig":{"mode":"MODE_DYNAMIC","dynamicThreshold":%g}}}`, gc.threshold))
+	}
+
+	// Ground the answer in the pages the user links to, besides the search results.
+	// The models with dynamic retrieval predate the urlContext tool.
+	if gc.urlContext && gc.threshold == 0 && len(messageURLs(ExtractUserQuery(claudePayload))) > 0 {
+		req, _ = sjson.SetRaw(req, "tools.-1", `{"urlContext":{}}`)
+	}
+
+	// Hybrid tool mode: declare client tools so Gemini can call them alongside search
+	if gc.hybridTools {
+		if decls := TransformTools(claudePayload); len(decls) > 0 {
+			declsJSON, err := json.Marshal(decls)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal function declarations: %w", err)
+			}
+			req, _ = sjson.SetRaw(req, "tools.-1", `{"functionDeclarations":`+string(declsJSON)+`}`)
+		}
+	}
+
 	return req, nil
 }
 
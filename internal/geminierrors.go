@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// AuthError means Gemini rejected the credentials (401/403). Retrying with the same
+// key is pointless until the key is fixed.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return "gemini authentication failed: " + e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// QuotaError means the key is valid but out of quota or rate limited. It is cooled
+// down for RetryAfter when Gemini says how long to wait.
+type QuotaError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string { return "gemini quota exceeded: " + e.Err.Error() }
+func (e *QuotaError) Unwrap() error { return e.Err }
+
+// TransientError means the request failed for reasons unrelated to the key (network
+// errors, 5xx responses) and is worth retrying.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return "gemini temporarily unavailable: " + e.Err.Error() }
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// classifyGeminiStatus wraps a non-2xx Gemini response in the error type matching its
+// cause. Quota errors are recognized by the RESOURCE_EXHAUSTED status as well as 429,
+// since Google also reports some quota failures as 403.
+func classifyGeminiStatus(resp *http.Response, body []byte) error {
+	statusErr := &geminiStatusError{StatusCode: resp.StatusCode, BodyBytes: len(body), BodySHA256: sha256Hex(body)}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || gjson.GetBytes(body, "error.status").String() == "RESOURCE_EXHAUSTED":
+		return &QuotaError{Err: statusErr, RetryAfter: parseRetryDelay(resp.Header, body)}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &AuthError{Err: statusErr}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return &TransientError{Err: statusErr}
+	default:
+		return statusErr
+	}
+}
+
+// classifyGeminiTransportError wraps a failed Gemini round trip. Errors caused by the
+// caller's context ending are returned as they are, since retrying can't help.
+func classifyGeminiTransportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &TransientError{Err: err}
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// SearchBackendGoogleCSE searches with a Google Programmable Search engine through the
+// Custom Search JSON API
+const SearchBackendGoogleCSE = "google_cse"
+
+const (
+	googleCSESearchPath = "/customsearch/v1"
+	// googleCSEMaxResults is the most results the Custom Search JSON API returns per request
+	googleCSEMaxResults = 10
+)
+
+func init() {
+	RegisterSearchBackend(SearchBackendGoogleCSE, newGoogleCSEBackend)
+}
+
+// googleCSEBackend runs web searches with the Custom Search JSON API, authenticated with
+// an API key, returning the results without an answer
+type googleCSEBackend struct {
+	searchURL  string
+	apiKey     string
+	cx         string
+	maxResults int
+	httpClient *http.Client
+}
+
+// newGoogleCSEBackend creates the Programmable Search backend from google_cse_api_key,
+// google_cse_cx and google_cse_base_url
+func newGoogleCSEBackend(cfg *Config, transport http.RoundTripper) (SearchBackend, error) {
+	if cfg.GoogleCSEAPIKey == "" || cfg.GoogleCSECX == "" {
+		return nil, fmt.Errorf("google_cse_api_key and google_cse_cx are required with search_backend %q",
+			SearchBackendGoogleCSE)
+	}
+	return &googleCSEBackend{
+		searchURL:  strings.TrimSuffix(cfg.GoogleCSEBaseURL, "/") + googleCSESearchPath,
+		apiKey:     cfg.GoogleCSEAPIKey,
+		cx:         cfg.GoogleCSECX,
+		maxResults: min(cfg.SearchMaxResults, googleCSEMaxResults),
+		httpClient: &http.Client{Timeout: 60 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Name returns the name of the Programmable Search backend
+func (gb *googleCSEBackend) Name() string {
+	return SearchBackendGoogleCSE
+}
+
+// KeyID returns a short, non-reversible identifier of the Custom Search API key
+func (gb *googleCSEBackend) KeyID() string {
+	return apiKeyID(gb.apiKey)
+}
+
+// ExecuteSearch searches the Programmable Search engine for the last user message
+func (gb *googleCSEBackend) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	query, err := searchQuery(ctx, claudePayload)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{
+		"key": {gb.apiKey},
+		"cx":  {gb.cx},
+		"q":   {query},
+		"num": {strconv.Itoa(gb.maxResults)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gb.searchURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	body, err := doEngineRequest(ctx, gb.httpClient, SearchBackendGoogleCSE, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseGoogleCSEResults(query, body).geminiResponse(), nil
+}
+
+// parseGoogleCSEResults collects the items of a Custom Search response, dated by their
+// article:published_time meta tag when pages have one
+func parseGoogleCSEResults(query string, body []byte) *SearchResults {
+	sr := &SearchResults{Query: query}
+	for _, item := range gjson.GetBytes(body, "items").Array() {
+		sr.Results = append(sr.Results, SearchResult{
+			Title:   item.Get("title").String(),
+			URL:     item.Get("link").String(),
+			Snippet: strings.Join(strings.Fields(item.Get("snippet").String()), " "),
+			PageAge: pageAge(item.Get("pagemap.metatags.0.article:published_time").String()),
+		})
+	}
+	return sr
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	anthropicVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	anthropicBetaPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+)
+
+// AnthropicBetas returns the feature flags listed in the anthropic-beta header(s)
+func AnthropicBetas(r *http.Request) []string {
+	var betas []string
+	for _, v := range r.Header.Values("anthropic-beta") {
+		for _, beta := range strings.Split(v, ",") {
+			if beta = strings.TrimSpace(beta); beta != "" {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	return betas
+}
+
+// validateAnthropicHeaders checks the anthropic-version and anthropic-beta headers of an
+// intercepted request the way the real API does, so malformed values fail consistently
+func validateAnthropicHeaders(r *http.Request) error {
+	if v := r.Header.Get("anthropic-version"); v != "" && !anthropicVersionPattern.MatchString(v) {
+		return fmt.Errorf("anthropic-version: invalid version %q", v)
+	}
+	for _, beta := range AnthropicBetas(r) {
+		if !anthropicBetaPattern.MatchString(beta) {
+			return fmt.Errorf("anthropic-beta: invalid beta flag %q", beta)
+		}
+	}
+	return nil
+}
+
+// setRequestID sets a synthetic request-id response header, like the one returned by the
+// real API, and returns it for logging
+func setRequestID(w http.ResponseWriter) string {
+	id := "req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	w.Header().Set("request-id", id)
+	return id
+}
+
+// HeaderRules adds, rewrites and strips headers on outgoing requests
+type HeaderRules struct {
+	// Headers to set, replacing any existing value
+	Set map[string]string `yaml:"set"`
+
+	// Headers to remove
+	Remove []string `yaml:"remove"`
+}
+
+// Apply removes and then sets headers according to the rules
+func (hr HeaderRules) Apply(h http.Header) {
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	for name, value := range hr.Set {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// errHedgeLost cancels the request that lost the race against its hedged counterpart
+var errHedgeLost = errors.New("hedged request with another key returned first")
+
+// hedgeResult is the outcome of one of the racing requests
+type hedgeResult struct {
+	resp []byte
+	err  error
+}
+
+// executeHedged performs the web search request with key k. When it takes longer than
+// hedgeAfter, the request is repeated with another available key and the first successful
+// response wins; the other request is cancelled. A failure waits for the other request.
+func (gc *GeminiClient) executeHedged(ctx context.Context, claudePayload []byte, k *geminiKey) ([]byte, error) {
+	if gc.hedgeAfter <= 0 {
+		return gc.attempt(ctx, claudePayload, k)
+	}
+
+	hedgeCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errHedgeLost)
+
+	results := make(chan hedgeResult, 2)
+	launch := func(k *geminiKey) {
+		go func() {
+			resp, err := gc.attempt(hedgeCtx, claudePayload, k)
+			results <- hedgeResult{resp: resp, err: err}
+		}()
+	}
+	launch(k)
+	pending := 1
+
+	timer := time.NewTimer(gc.hedgeAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			hedge := gc.keys.alternate(k, keyLabelFrom(ctx))
+			if hedge == nil {
+				continue
+			}
+			slog.Info("Gemini request is slow, hedging with another key", "key", k.id, "hedge_key", hedge.id,
+				"after", gc.hedgeAfter)
+			launch(hedge)
+			pending++
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				return r.resp, r.err
+			}
+		}
+	}
+}
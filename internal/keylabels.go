@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+)
+
+// keyLabelHeader selects the Gemini keys a request draws from, e.g. "team=research"
+const keyLabelHeader = "x-gemini-key-label"
+
+type keyLabelKey struct{}
+
+// withKeyLabel restricts the Gemini keys used for searches under ctx to those carrying label
+func withKeyLabel(ctx context.Context, label string) context.Context {
+	if label == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, keyLabelKey{}, label)
+}
+
+// keyLabelFrom returns the key label attached to ctx, or "" for any key
+func keyLabelFrom(ctx context.Context) string {
+	label, _ := ctx.Value(keyLabelKey{}).(string)
+	return label
+}
+
+// keyLabel returns the Gemini key label a request draws from: the one configured for its
+// proxy API key in proxy_api_key_labels, otherwise the x-gemini-key-label header
+func (p *Proxy) keyLabel(r *http.Request) string {
+	labels := *p.clientLabels.Load()
+	for _, presented := range clientAPIKeys(r) {
+		if label, ok := labels[presented]; ok {
+			return label
+		}
+	}
+	return r.Header.Get(keyLabelHeader)
+}
+
+// hasLabel reports whether k may serve requests for label ("" matches every key)
+func (k *geminiKey) hasLabel(label string) bool {
+	if label == "" {
+		return true
+	}
+	for _, l := range k.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
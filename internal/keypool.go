@@ -0,0 +1,434 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errKeyDisabledByAdmin takes a key out of the rotation until an admin enables it again;
+// unlike keys disabled after errors, resetting the pool leaves it out
+var errKeyDisabledByAdmin = errors.New("gemini key disabled by an admin")
+
+const (
+	// keyRateWindow is the window of the per-key requests per minute limit
+	keyRateWindow = time.Minute
+	// keyErrorDecay is the weight of the latest outcome in a key's error rate
+	keyErrorDecay = 0.2
+	// keyErrorWeight converts a key's error rate into in-flight requests when comparing
+	// load: a key failing every request counts as this many requests busier
+	keyErrorWeight = 4
+)
+
+// geminiKey is one credential of the Gemini key pool
+type geminiKey struct {
+	id        string
+	key       string    // empty with Application Default Credentials
+	labels    []string  // e.g. "tier=paid", selecting keys for some clients
+	coolUntil time.Time // no requests with this key before
+	disabled  error     // why the key is out of the rotation until reset
+	inFlight  int
+	starts    []time.Time // request start times within keyRateWindow
+	errorRate float64     // moving average of failed requests, 0-1
+}
+
+// keyPool rotates web searches across Gemini API keys. Requests stick to the current
+// key until it fails with an auth or quota error; the key then cools down and the
+// next available one takes over. Optional per-key limits on concurrent requests and
+// requests per minute spread bursts over the other keys.
+type keyPool struct {
+	mu          sync.Mutex
+	keys        []*geminiKey
+	current     int
+	maxInFlight int           // per key, 0 = unlimited
+	perMinute   int           // per key, 0 = unlimited
+	leastLoaded bool          // pick the least loaded key instead of sticking to one
+	freed       chan struct{} // closed and replaced when a request finishes
+
+	// quotaReached, when set, reports keys that used up their daily request limit; they
+	// sit out until the counters roll over at UTC midnight
+	quotaReached func(keyID string) bool
+}
+
+// newKeyPool creates a pool of API keys, labeled by key ID
+func newKeyPool(keys []string, labels map[string][]string) *keyPool {
+	kp := &keyPool{freed: make(chan struct{})}
+	kp.set(keys, labels)
+	return kp
+}
+
+// newCredentialsPool creates a single-entry pool for OAuth credentials identified by id
+func newCredentialsPool(id string, labels map[string][]string) *keyPool {
+	return &keyPool{keys: []*geminiKey{{id: id, labels: labels[id]}}, freed: make(chan struct{})}
+}
+
+// apiKeyID returns a short, non-reversible identifier of an API key
+func apiKeyID(key string) string {
+	return "key-" + sha256Hex([]byte(key))[:8]
+}
+
+// set replaces the keys of the pool, keeping the cooldown of keys that remain
+func (kp *keyPool) set(keys []string, labels map[string][]string) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	previous := make(map[string]*geminiKey, len(kp.keys))
+	for _, k := range kp.keys {
+		previous[k.key] = k
+	}
+	current := ""
+	if len(kp.keys) > 0 {
+		current = kp.keys[kp.current].key
+	}
+
+	kp.keys = make([]*geminiKey, 0, len(keys))
+	kp.current = 0
+	for _, key := range keys {
+		k, ok := previous[key]
+		if !ok {
+			k = &geminiKey{id: apiKeyID(key), key: key}
+		}
+		k.labels = labels[k.id]
+		if key == current {
+			kp.current = len(kp.keys)
+		}
+		kp.keys = append(kp.keys, k)
+	}
+}
+
+// all returns the keys of the pool in rotation order
+func (kp *keyPool) all() []*geminiKey {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return append([]*geminiKey(nil), kp.keys...)
+}
+
+// size returns the number of keys in the pool
+func (kp *keyPool) size() int {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return len(kp.keys)
+}
+
+// acquire reserves a key carrying label for a request: the current key, or the next one
+// not cooling down and within its limits. With leastLoaded it is the usable key with the
+// fewest requests in flight, weighted by its recent error rate. While keys are only at
+// their limits it waits for one to free up. When every key is cooling down it returns nil
+// and how long until the first one is available again. Reserved keys must be released.
+func (kp *keyPool) acquire(ctx context.Context, label string) (*geminiKey, time.Duration, error) {
+	for {
+		kp.mu.Lock()
+		now := time.Now()
+		var wait, busyFor time.Duration
+		skippedBusy, labeled := false, false
+		var disabled error
+		start := kp.current
+		if kp.leastLoaded {
+			// Start after the last key picked, so equally loaded keys take turns
+			start++
+		}
+		best, bestLoad := -1, 0.0
+		for i := range kp.keys {
+			idx := (start + i) % len(kp.keys)
+			k := kp.keys[idx]
+			if !k.hasLabel(label) {
+				// Keys of other labels are not out of the rotation
+				skippedBusy = true
+				continue
+			}
+			labeled = true
+			if k.disabled != nil {
+				disabled = k.disabled
+				continue
+			}
+			if remaining := kp.coolingFor(k, now); remaining > 0 {
+				if wait == 0 || remaining < wait {
+					wait = remaining
+				}
+				continue
+			}
+			if free := kp.freeIn(k, now); free > 0 {
+				if busyFor == 0 || free < busyFor {
+					busyFor = free
+				}
+				skippedBusy = true
+				continue
+			}
+			if kp.leastLoaded {
+				if load := k.load(); best < 0 || load < bestLoad {
+					best, bestLoad = idx, load
+				}
+				continue
+			}
+			// Only keys out of the rotation move the current key on; a busy current
+			// key stays current
+			if !skippedBusy {
+				kp.current = idx
+			}
+			best = idx
+			break
+		}
+		if best >= 0 {
+			k := kp.keys[best]
+			if kp.leastLoaded {
+				kp.current = best
+			}
+			kp.reserve(k, now)
+			kp.mu.Unlock()
+			return k, 0, nil
+		}
+		freed := kp.freed
+		empty := len(kp.keys) == 0
+		kp.mu.Unlock()
+
+		if empty {
+			return nil, 0, fmt.Errorf("no gemini API keys are configured")
+		}
+		if !labeled {
+			return nil, 0, fmt.Errorf("no gemini key is labeled %q", label)
+		}
+		if busyFor == 0 && wait == 0 && disabled != nil {
+			return nil, 0, disabled
+		}
+		if busyFor == 0 {
+			return nil, wait, nil
+		}
+		timer := time.NewTimer(busyFor)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, ctx.Err()
+		case <-freed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// alternate reserves the next key after k carrying label that is not cooling down and
+// within its limits, without making it current, or returns nil when there is none
+func (kp *keyPool) alternate(k *geminiKey, label string) *geminiKey {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	now := time.Now()
+	for i := range kp.keys {
+		other := kp.keys[(kp.current+i)%len(kp.keys)]
+		if other != k && other.hasLabel(label) && other.disabled == nil && kp.coolingFor(other, now) == 0 &&
+			kp.freeIn(other, now) == 0 {
+			kp.reserve(other, now)
+			return other
+		}
+	}
+	return nil
+}
+
+// coolingFor returns how long k stays out of the rotation after errors or for its daily
+// limit, 0 when it is available. Callers hold mu.
+func (kp *keyPool) coolingFor(k *geminiKey, now time.Time) time.Duration {
+	if kp.quotaReached != nil && kp.quotaReached(k.id) {
+		return untilNextDay()
+	}
+	return max(k.coolUntil.Sub(now), 0)
+}
+
+// freeIn returns how long until k is within its limits again, 0 when it is. A key at its
+// concurrency limit reports the whole rate window; a finishing request wakes waiters
+// earlier. Callers hold mu.
+func (kp *keyPool) freeIn(k *geminiKey, now time.Time) time.Duration {
+	var free time.Duration
+	if kp.perMinute > 0 {
+		cutoff := now.Add(-keyRateWindow)
+		for len(k.starts) > 0 && !k.starts[0].After(cutoff) {
+			k.starts = k.starts[1:]
+		}
+		if len(k.starts) >= kp.perMinute {
+			free = k.starts[len(k.starts)-kp.perMinute].Sub(cutoff)
+		}
+	}
+	if kp.maxInFlight > 0 && k.inFlight >= kp.maxInFlight {
+		free = max(free, keyRateWindow)
+	}
+	return free
+}
+
+// load weighs the requests in flight with k by its recent error rate. Callers hold mu.
+func (k *geminiKey) load() float64 {
+	return float64(k.inFlight) + keyErrorWeight*k.errorRate
+}
+
+// observe updates the error rate of k with the outcome of a request
+func (kp *keyPool) observe(k *geminiKey, failed bool) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	outcome := 0.0
+	if failed {
+		outcome = 1
+	}
+	k.errorRate += keyErrorDecay * (outcome - k.errorRate)
+}
+
+// reserve counts a request started with k. Callers hold mu.
+func (kp *keyPool) reserve(k *geminiKey, now time.Time) {
+	k.inFlight++
+	if kp.perMinute > 0 {
+		k.starts = append(k.starts, now)
+	}
+}
+
+// release ends a request reserved with acquire or alternate
+func (kp *keyPool) release(k *geminiKey) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	k.inFlight--
+	close(kp.freed)
+	kp.freed = make(chan struct{})
+}
+
+// coolDown stops requests with k for d and moves on to the next key
+func (kp *keyPool) coolDown(k *geminiKey, d time.Duration) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if until := time.Now().Add(d); until.After(k.coolUntil) {
+		k.coolUntil = until
+	}
+	kp.advance(k)
+}
+
+// rotate moves on from k to the next key without a cooldown
+func (kp *keyPool) rotate(k *geminiKey) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	kp.advance(k)
+}
+
+// advance makes the key after k current if k is the current key. Callers hold mu.
+func (kp *keyPool) advance(k *geminiKey) {
+	if len(kp.keys) > 0 && kp.keys[kp.current] == k {
+		kp.current = (kp.current + 1) % len(kp.keys)
+	}
+}
+
+// disable takes k out of the rotation until the pool is reset
+func (kp *keyPool) disable(k *geminiKey, reason error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	k.disabled = reason
+	kp.advance(k)
+}
+
+// reset lets requests with every key through again immediately, except for keys an
+// admin disabled
+func (kp *keyPool) reset() {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	for _, k := range kp.keys {
+		k.coolUntil = time.Time{}
+		if k.disabled != errKeyDisabledByAdmin {
+			k.disabled = nil
+		}
+	}
+}
+
+// byID returns the key with the given identifier, or nil. Callers hold mu.
+func (kp *keyPool) byID(id string) *geminiKey {
+	for _, k := range kp.keys {
+		if k.id == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// disableKey takes the key with the given identifier out of the rotation until it is
+// enabled again. It reports false when there is no such key.
+func (kp *keyPool) disableKey(id string) bool {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	k := kp.byID(id)
+	if k == nil {
+		return false
+	}
+	k.disabled = errKeyDisabledByAdmin
+	kp.advance(k)
+	return true
+}
+
+// enableKey puts the key with the given identifier back in the rotation, clearing its
+// cooldown and error rate. It reports false when there is no such key.
+func (kp *keyPool) enableKey(id string) bool {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	k := kp.byID(id)
+	if k == nil {
+		return false
+	}
+	k.disabled = nil
+	k.coolUntil = time.Time{}
+	k.errorRate = 0
+	return true
+}
+
+// activateKey makes the key with the given identifier current. It reports false when
+// there is no such key.
+func (kp *keyPool) activateKey(id string) bool {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	for i, k := range kp.keys {
+		if k.id == id {
+			kp.current = i
+			return true
+		}
+	}
+	return false
+}
+
+// currentID returns the identifier of the current key
+func (kp *keyPool) currentID() string {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if len(kp.keys) == 0 {
+		return apiKeyID("")
+	}
+	return kp.keys[kp.current].id
+}
+
+// errorString returns the message of err, or "" when it is nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// keyStatus describes a key of the pool for the admin API
+type keyStatus struct {
+	ID        string
+	Current   bool
+	Cooldown  time.Duration
+	InFlight  int
+	Labels    []string
+	Disabled  string
+	ErrorRate float64
+}
+
+// status lists the keys of the pool in rotation order
+func (kp *keyPool) status() []keyStatus {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	statuses := make([]keyStatus, 0, len(kp.keys))
+	for i, k := range kp.keys {
+		statuses = append(statuses, keyStatus{
+			ID:        k.id,
+			Current:   i == kp.current,
+			Cooldown:  max(time.Until(k.coolUntil), 0),
+			InFlight:  k.inFlight,
+			Labels:    k.labels,
+			Disabled:  errorString(k.disabled),
+			ErrorRate: k.errorRate,
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// OpenListeners opens the configured listeners: TCP on each of ListenAddresses, or on
+// ListenHost:ListenPort unless the port is 0 (wrapped in TLS when a certificate is
+// configured), and a Unix domain socket at ListenSocket when set. Sockets passed by
+// systemd socket activation replace the configured addresses.
+func OpenListeners(cfg *Config) ([]net.Listener, error) {
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	activated, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(activated) > 0 {
+		for i, l := range activated {
+			if tlsConfig != nil && l.Addr().Network() == "tcp" {
+				activated[i] = tls.NewListener(l, tlsConfig)
+			}
+		}
+		return activated, nil
+	}
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	var lc net.ListenConfig
+	if cfg.ListenReusePort {
+		lc.Control = reusePortControl
+	}
+
+	addrs := cfg.ListenAddresses
+	if len(addrs) == 0 && cfg.ListenPort != 0 {
+		addrs = []string{net.JoinHostPort(cfg.ListenHost, strconv.Itoa(cfg.ListenPort))}
+	}
+	for _, addr := range addrs {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if cfg.ListenSocket != "" {
+		// Remove a stale socket left behind by a previous run
+		if fi, err := os.Stat(cfg.ListenSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(cfg.ListenSocket)
+		}
+		l, err := net.Listen("unix", cfg.ListenSocket)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listeners configured: set listen_port, listen_addresses or listen_socket")
+	}
+	return listeners, nil
+}
+
+// serverTLSConfig builds the TLS config for the TCP listener, or nil when TLS is not configured
+func serverTLSConfig(cfg *Config) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	switch {
+	case len(cfg.TLSAutocertDomains) > 0:
+		manager, err := newAutocertManager(cfg)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = manager.TLSConfig()
+	case cfg.TLSCert != "":
+		certs, err := newCertLoader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	default:
+		return nil, nil
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	// With a client CA, unauthenticated clients fail the handshake and never reach ServeHTTP
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", cfg.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// newAutocertManager sets up fetching and renewing the certificates of tls_autocert_domains
+// from Let's Encrypt
+func newAutocertManager(cfg *Config) (*autocert.Manager, error) {
+	cacheDir := cfg.TLSAutocertCacheDir
+	if cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("no tls_autocert_cache_dir set and no user cache directory: %w", err)
+		}
+		cacheDir = filepath.Join(userCache, "cpa_websearch_proxy", "autocert")
+	}
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create tls_autocert_cache_dir: %w", err)
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      cfg.TLSAutocertEmail,
+	}, nil
+}
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// certLoader serves the certificate of tls_cert/tls_key, reloading it when either file
+// changes so renewed certificates are picked up without a restart
+type certLoader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// newCertLoader loads the certificate, failing when it can't be read
+func newCertLoader(certFile, keyFile string) (*certLoader, error) {
+	cl := &certLoader{certFile: certFile, keyFile: keyFile}
+	modTime, err := cl.latestModTime()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if err := cl.load(modTime); err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return cl, nil
+}
+
+// getCertificate returns the current certificate, reloading it first when the files
+// changed since it was last checked. A broken update keeps the previous certificate in use.
+func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if time.Since(cl.checkedAt) < certCheckInterval {
+		return cl.cert, nil
+	}
+	cl.checkedAt = time.Now()
+	modTime, err := cl.latestModTime()
+	if err != nil || modTime.Equal(cl.modTime) {
+		return cl.cert, nil
+	}
+	if err := cl.load(modTime); err != nil {
+		// Certificate and key are often written one after the other; retry on the next check
+		slog.Warn("Failed to reload TLS certificate, keeping the previous one", "error", err)
+		return cl.cert, nil
+	}
+	slog.Info("Reloaded TLS certificate", "cert", cl.certFile)
+	return cl.cert, nil
+}
+
+// load reads the certificate and key, recording modTime as the version loaded
+func (cl *certLoader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cl.certFile, cl.keyFile)
+	if err != nil {
+		return err
+	}
+	cl.cert = &cert
+	cl.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the later of the modification times of the two files
+func (cl *certLoader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(cl.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(cl.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Log output sinks
+const (
+	LogOutputStderr   = "stderr"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// syslogTag identifies the proxy's messages in syslog
+const syslogTag = "cpa_websearch_proxy"
+
+// logLevel is the minimum level logged, adjustable at runtime on reload
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel maps a log_level setting to a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log_level %q (expected debug, info, warn or error)", level)
+	}
+}
+
+// SetupLogging installs the default slog logger with the configured level, format and
+// output. Output from the standard log package is routed through it at info level.
+func SetupLogging(cfg *Config) error {
+	setLogLevel(cfg.LogLevel)
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	newHandler := func(w io.Writer) slog.Handler {
+		if cfg.LogFormat == LogFormatJSON {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	var handler slog.Handler
+	switch cfg.LogOutput {
+	case LogOutputSyslog, LogOutputJournald:
+		// The collector timestamps every message itself
+		opts.ReplaceAttr = dropTime
+		writers := journaldWriters()
+		if cfg.LogOutput == LogOutputSyslog {
+			var err error
+			if writers, err = syslogWriters(syslogTag); err != nil {
+				return err
+			}
+		}
+		h := &severityHandler{}
+		for i, w := range writers {
+			h.handlers[i] = newHandler(w)
+		}
+		handler = h
+	default:
+		handler = newHandler(os.Stderr)
+	}
+	slog.SetDefault(slog.New(&eventHandler{base: handler, hub: adminEvents}))
+	return nil
+}
+
+// setLogLevel changes the minimum level logged; invalid levels are rejected by LoadConfig
+func setLogLevel(level string) {
+	l, _ := parseLogLevel(level)
+	logLevel.Set(l)
+}
+
+// debugEnabled reports whether debug messages are currently logged, so expensive
+// debug-only work can be skipped
+func debugEnabled() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
+
+// dropTime removes the top-level time attribute from log records
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// priorityWriter adapts a per-priority log function (such as a syslog.Writer method) to io.Writer
+type priorityWriter func(string) error
+
+func (f priorityWriter) Write(b []byte) (int, error) {
+	if err := f(strings.TrimSuffix(string(b), "\n")); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// journaldWriters returns stderr writers that prefix each line with its sd-daemon
+// priority (<7> debug, <6> info, <4> warning, <3> error), which journald maps to the
+// message priority when the proxy runs as a systemd service
+func journaldWriters() [4]io.Writer {
+	var writers [4]io.Writer
+	for i, prefix := range []string{"<7>", "<6>", "<4>", "<3>"} {
+		writers[i] = priorityWriter(func(line string) error {
+			_, err := os.Stderr.WriteString(prefix + line + "\n")
+			return err
+		})
+	}
+	return writers
+}
+
+// severityHandler dispatches each record to the handler for its severity, so every
+// severity can be written to a differently prioritized sink
+type severityHandler struct {
+	handlers [4]slog.Handler // debug, info, warning, error
+}
+
+// severityIndex maps a slog level to its index in severityHandler.handlers
+func severityIndex(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 0
+	case level < slog.LevelWarn:
+		return 1
+	case level < slog.LevelError:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func (h *severityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handlers[severityIndex(level)].Enabled(ctx, level)
+}
+
+func (h *severityHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handlers[severityIndex(r.Level)].Handle(ctx, r)
+}
+
+func (h *severityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := &severityHandler{}
+	for i, handler := range h.handlers {
+		out.handlers[i] = handler.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h *severityHandler) WithGroup(name string) slog.Handler {
+	out := &severityHandler{}
+	for i, handler := range h.handlers {
+		out.handlers[i] = handler.WithGroup(name)
+	}
+	return out
+}
+
+// Fatal logs an error and exits, regardless of the configured log level
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// syslogWriters is unavailable on platforms without syslog
+func syslogWriters(tag string) ([4]io.Writer, error) {
+	return [4]io.Writer{}, fmt.Errorf("log_output %q is not supported on %s", LogOutputSyslog, runtime.GOOS)
+}
@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package internal
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// syslogWriters connects to the local syslog daemon and returns one writer per
+// severity: debug, info, warning and error
+func syslogWriters(tag string) ([4]io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return [4]io.Writer{}, err
+	}
+	return [4]io.Writer{
+		priorityWriter(w.Debug),
+		priorityWriter(w.Info),
+		priorityWriter(w.Warning),
+		priorityWriter(w.Err),
+	}, nil
+}
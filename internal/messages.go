@@ -33,6 +33,39 @@ type GeminiFunctionResponse struct {
 	ID       string                 `json:"id,omitempty"`
 }
 
+// GeminiFunctionDeclaration represents a client tool declared to Gemini
+type GeminiFunctionDeclaration struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description,omitempty"`
+	ParametersJSONSchema json.RawMessage `json:"parametersJsonSchema,omitempty"`
+}
+
+// TransformTools converts Claude client tools to Gemini function declarations.
+// Server tools such as web_search are skipped since Gemini provides its own search.
+func TransformTools(claudePayload []byte) []GeminiFunctionDeclaration {
+	tools := gjson.GetBytes(claudePayload, "tools")
+	if !tools.IsArray() {
+		return nil
+	}
+
+	var decls []GeminiFunctionDeclaration
+	for _, tool := range tools.Array() {
+		// Client tools have an input_schema; server tools are identified by type only
+		schema := tool.Get("input_schema")
+		name := tool.Get("name").String()
+		if isWebSearchTool(tool) || !schema.IsObject() || name == "" {
+			continue
+		}
+
+		decls = append(decls, GeminiFunctionDeclaration{
+			Name:                 name,
+			Description:          tool.Get("description").String(),
+			ParametersJSONSchema: json.RawMessage(schema.Raw),
+		})
+	}
+	return decls
+}
+
 // TransformMessages converts Claude messages to Gemini contents format
 // Returns the transformed contents array ready for Gemini API
 func TransformMessages(claudePayload []byte) ([]GeminiContent, error) {
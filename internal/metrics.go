@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics holds cumulative named counters. Names are dot-separated, e.g. "upstream.errors.timeout".
+type Metrics struct {
+	counters sync.Map // map[string]*atomic.Int64
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Inc increments the named counter by one
+func (m *Metrics) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Add increments the named counter by delta
+func (m *Metrics) Add(name string, delta int64) {
+	if c, ok := m.counters.Load(name); ok {
+		c.(*atomic.Int64).Add(delta)
+		return
+	}
+	c, _ := m.counters.LoadOrStore(name, new(atomic.Int64))
+	c.(*atomic.Int64).Add(delta)
+}
+
+// Snapshot returns the current value of every counter
+func (m *Metrics) Snapshot() map[string]int64 {
+	out := make(map[string]int64)
+	m.counters.Range(func(k, v interface{}) bool {
+		out[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return out
+}
+
+// Names returns the sorted names of all counters
+func (m *Metrics) Names() []string {
+	var names []string
+	m.counters.Range(func(k, _ interface{}) bool {
+		names = append(names, k.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const modelsFetchTimeout = 10 * time.Second
+
+// handleModels answers GET /v1/models locally. The upstream model list is merged in when
+// available, and every Claude model is annotated with web_search availability.
+func (p *Proxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	list := `{"data":[],"has_more":false,"first_id":null,"last_id":null}`
+
+	if upstream := p.upstreamFor(""); upstream != nil {
+		upstreamList, err := p.fetchUpstreamModels(r.Context(), upstream, r)
+		if err != nil {
+			slog.Warn("Failed to fetch upstream models, serving local list", "error", err)
+		} else {
+			list = upstreamList
+		}
+	}
+
+	for i, m := range gjson.Get(list, "data").Array() {
+		if IsClaudeModel(m.Get("id").String()) {
+			list, _ = sjson.Set(list, fmt.Sprintf("data.%d.web_search", i), true)
+		}
+	}
+	// Report the backend this caller's searches go to; the model only applies to Gemini
+	webSearch := map[string]interface{}{
+		"available": true,
+		"backend":   p.backendName(r.Context()),
+	}
+	if webSearch["backend"] == "gemini" {
+		webSearch["model"] = p.cfg.WebSearchModel
+	}
+	list, _ = sjson.Set(list, "web_search", webSearch)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(list))
+}
+
+// fetchUpstreamModels retrieves the model list from the upstream using the client's credentials
+func (p *Proxy) fetchUpstreamModels(ctx context.Context, upstream *UpstreamPool, r *http.Request) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, modelsFetchTimeout)
+	defer cancel()
+
+	path := r.URL.Path
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+
+	status, body, err := p.doUpstreamRequest(ctx, upstream, r, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	if status < 200 || status >= 300 {
+		return "", fmt.Errorf("upstream returned status %d", status)
+	}
+	if !json.Valid(body) || !gjson.GetBytes(body, "data").IsArray() {
+		return "", fmt.Errorf("upstream returned an invalid model list")
+	}
+	return string(body), nil
+}
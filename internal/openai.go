@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// onlineModelSuffix marks an OpenAI-format model name as requesting web search (e.g. "gpt-4o:online")
+const onlineModelSuffix = ":online"
+
+// IsOpenAIWebSearchRequest checks if an OpenAI chat.completions payload requests web search,
+// either through a web_search tool or the ":online" model suffix
+func IsOpenAIWebSearchRequest(payload []byte) bool {
+	if strings.HasSuffix(gjson.GetBytes(payload, "model").String(), onlineModelSuffix) {
+		return true
+	}
+	for _, tool := range gjson.GetBytes(payload, "tools").Array() {
+		// Match web_search, web_search_preview, etc.
+		if strings.HasPrefix(tool.Get("type").String(), "web_search") {
+			return true
+		}
+	}
+	return gjson.GetBytes(payload, "web_search_options").Exists()
+}
+
+// OpenAIToClaudePayload converts OpenAI chat messages into a Claude messages payload
+// so the request can run through the same Gemini search pipeline
+func OpenAIToClaudePayload(payload []byte) []byte {
+	var system []string
+	var messages []map[string]interface{}
+
+	for _, msg := range gjson.GetBytes(payload, "messages").Array() {
+		text := openAIMessageText(msg.Get("content"))
+		if text == "" {
+			continue
+		}
+
+		switch msg.Get("role").String() {
+		case "system", "developer":
+			system = append(system, text)
+		case "assistant":
+			messages = append(messages, map[string]interface{}{"role": "assistant", "content": text})
+		default:
+			messages = append(messages, map[string]interface{}{"role": "user", "content": text})
+		}
+	}
+
+	out := map[string]interface{}{"messages": messages}
+	if len(system) > 0 {
+		out["system"] = strings.Join(system, "\n\n")
+	}
+	claudePayload, _ := json.Marshal(out)
+	return claudePayload
+}
+
+// openAIMessageText extracts the text of an OpenAI message content (string or parts array)
+func openAIMessageText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+
+	var parts []string
+	for _, part := range content.Array() {
+		if part.Get("type").String() == "text" {
+			parts = append(parts, part.Get("text").String())
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// handleChatCompletions handles OpenAI-format chat completions, running web search requests
+// through Gemini and forwarding everything else upstream
+func (p *Proxy) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	model := gjson.GetBytes(body, "model").String()
+	if !IsOpenAIWebSearchRequest(body) {
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
+		return
+	}
+
+	if !p.acquireSearchSlot() {
+		p.rejectOverloaded(w, model)
+		writeOpenAIError(w, http.StatusTooManyRequests, "rate_limit_exceeded", "Too many concurrent web searches, please retry shortly")
+		return
+	}
+	defer p.releaseSearchSlot()
+
+	model = strings.TrimSuffix(model, onlineModelSuffix)
+	slog.Info("web_search detected for OpenAI chat request, routing to Gemini", "model", model)
+
+	ctx := r.Context()
+	geminiResp, err := p.executeSearch(ctx, OpenAIToClaudePayload(body))
+	if err != nil {
+		slog.Error("Web search failed", "backend", p.backendName(ctx), "error", err)
+		writeOpenAIError(w, http.StatusBadGateway, "server_error", "Web search temporarily unavailable")
+		return
+	}
+
+	if gjson.GetBytes(body, "stream").Bool() {
+		p.writeOpenAIStream(ctx, w, model, geminiResp)
+	} else {
+		p.writeOpenAICompletion(w, r, model, geminiResp)
+	}
+}
+
+// buildOpenAIAnnotations converts Gemini grounding supports into OpenAI url_citation
+// annotations, translating Gemini's UTF-8 byte offsets into character offsets
+func buildOpenAIAnnotations(text string, supports gjson.Result, results []map[string]interface{}) []map[string]interface{} {
+	annotations := []map[string]interface{}{}
+
+	for _, support := range supports.Array() {
+		citation := buildCitation(support, results)
+		if citation == nil {
+			continue
+		}
+
+		start := int(support.Get("segment.startIndex").Int())
+		end := int(support.Get("segment.endIndex").Int())
+		if start < 0 || end > len(text) || start > end {
+			continue
+		}
+
+		annotations = append(annotations, map[string]interface{}{
+			"type": "url_citation",
+			"url_citation": map[string]interface{}{
+				"url":         citation.URL,
+				"title":       citation.Title,
+				"start_index": utf8.RuneCountInString(text[:start]),
+				"end_index":   utf8.RuneCountInString(text[:end]),
+			},
+		})
+	}
+	return annotations
+}
+
+// openAICompletionParts extracts the answer text, citations and usage for an OpenAI response
+func (p *Proxy) openAICompletionParts(ctx context.Context, geminiResp []byte) (string, []map[string]interface{}, map[string]int64) {
+	text := extractTextContent(geminiResp)
+	results := extractWebSearchResultsWithResolve(ctx, extractGroundingMetadata(geminiResp), p.urlResolver)
+	annotations := buildOpenAIAnnotations(text, extractGroundingSupports(geminiResp), results)
+
+	promptTokens := getUsageField(geminiResp, "promptTokenCount")
+	completionTokens := getUsageField(geminiResp, "candidatesTokenCount")
+	usage := map[string]int64{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+	}
+	return text, annotations, usage
+}
+
+// writeOpenAICompletion writes a non-streaming chat.completion response
+func (p *Proxy) writeOpenAICompletion(w http.ResponseWriter, r *http.Request, model string, geminiResp []byte) {
+	text, annotations, usage := p.openAICompletionParts(r.Context(), geminiResp)
+
+	response := map[string]interface{}{
+		"id":      newChatCompletionID(),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"message": map[string]interface{}{
+					"role":        "assistant",
+					"content":     text,
+					"annotations": annotations,
+				},
+				"finish_reason": "stop",
+			},
+		},
+		"usage": usage,
+	}
+
+	respJSON, _ := json.Marshal(response)
+	p.writeJSON(w, r, http.StatusOK, respJSON)
+}
+
+// writeOpenAIStream writes a streaming chat.completion.chunk response
+func (p *Proxy) writeOpenAIStream(ctx context.Context, w http.ResponseWriter, model string, geminiResp []byte) {
+	text, annotations, usage := p.openAICompletionParts(ctx, geminiResp)
+
+	id := newChatCompletionID()
+	created := time.Now().Unix()
+	chunk := func(delta map[string]interface{}, finishReason interface{}) string {
+		c := fmt.Sprintf(`{"id":"","object":"chat.completion.chunk","created":%d,"model":"","choices":[{"index":0,"delta":{},"finish_reason":null}]}`, created)
+		c, _ = sjson.Set(c, "id", id)
+		c, _ = sjson.Set(c, "model", model)
+		c, _ = sjson.Set(c, "choices.0.delta", delta)
+		c, _ = sjson.Set(c, "choices.0.finish_reason", finishReason)
+		return c
+	}
+
+	sw := newSSEWriter(w)
+	send := func(data string) { sw.Send("data: " + data + "\n\n") }
+	send(chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil))
+
+	// Use rune-based chunking to avoid UTF-8 multi-byte character truncation
+	runes := []rune(text)
+	chunkSize := 50
+	for i := 0; i < len(runes); i += chunkSize {
+		end := i + chunkSize
+		if end > len(runes) {
+			end = len(runes)
+		}
+		send(chunk(map[string]interface{}{"content": string(runes[i:end])}, nil))
+	}
+
+	final, _ := sjson.Set(chunk(map[string]interface{}{"annotations": annotations}, "stop"), "usage", usage)
+	send(final)
+	send("[DONE]")
+}
+
+// newChatCompletionID generates an OpenAI-style chat completion ID
+func newChatCompletionID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// writeOpenAIError writes an OpenAI-formatted JSON error response
+func writeOpenAIError(w http.ResponseWriter, status int, code, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    code,
+			"code":    code,
+		},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
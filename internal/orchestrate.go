@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// BuildSearchResultBlocks runs the converter over a Gemini response and returns only the
+// server_tool_use and web_search_tool_result blocks, without Gemini's own answer
+func BuildSearchResultBlocks(ctx context.Context, geminiResp []byte, resolver *URLResolver) []map[string]interface{} {
+	blocks, _ := buildSearchToolBlocks(ctx, extractGroundingMetadata(geminiResp), resolver)
+	return blocks
+}
+
+// InjectSearchResults appends the search blocks to the conversation as an assistant turn
+// so the upstream model continues its answer from the search results. If the conversation
+// already ends with an assistant message, the blocks are appended to that message instead.
+func InjectSearchResults(payload []byte, blocks []map[string]interface{}) ([]byte, error) {
+	messages := gjson.GetBytes(payload, "messages")
+	arr := messages.Array()
+
+	if n := len(arr); n > 0 && arr[n-1].Get("role").String() == "assistant" {
+		last := arr[n-1]
+		var content []interface{}
+		if c := last.Get("content"); c.Type == gjson.String {
+			if c.String() != "" {
+				content = append(content, map[string]interface{}{"type": "text", "text": c.String()})
+			}
+		} else if c.IsArray() {
+			content = c.Value().([]interface{})
+		}
+		for _, block := range blocks {
+			content = append(content, block)
+		}
+
+		contentJSON, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		return sjson.SetRawBytes(payload, "messages."+strconv.Itoa(n-1)+".content", contentJSON)
+	}
+
+	msgJSON, err := json.Marshal(map[string]interface{}{
+		"role":    "assistant",
+		"content": blocks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(payload, "messages.-1", msgJSON)
+}
+
+// orchestrateWebSearch runs the Gemini search, injects its results into the conversation and
+// forwards the augmented payload upstream so the final answer is written by Claude itself
+func (p *Proxy) orchestrateWebSearch(w http.ResponseWriter, r *http.Request, body []byte, model string) {
+	ctx := r.Context()
+
+	geminiResp, err := p.executeSearch(ctx, body)
+	if err != nil {
+		slog.Error("Web search failed", "backend", p.backendName(ctx), "error", err)
+		if p.fallbackEnabled(model) {
+			p.forwardWithoutWebSearch(w, r, body, model)
+			return
+		}
+		writeError(w, http.StatusBadGateway, errTypeAPI, "Web search temporarily unavailable")
+		return
+	}
+
+	blocks := BuildSearchResultBlocks(ctx, geminiResp, p.urlResolver)
+
+	// The upstream does not support the web_search server tool, so remove it
+	// before handing over the conversation with the injected results
+	augmented, err := StripServerTools(body)
+	if err == nil {
+		augmented, err = InjectSearchResults(augmented, blocks)
+	}
+	if err != nil {
+		slog.Error("Failed to inject search results", "error", err)
+		writeError(w, http.StatusInternalServerError, errTypeAPI, "Failed to build upstream request")
+		return
+	}
+
+	slog.Debug("Forwarding conversation with injected search blocks upstream", "blocks", len(blocks))
+
+	setRequestBody(r, augmented)
+	w.Header().Del("request-id") // the upstream supplies its own
+	p.upstreamFor(model).ServeHTTP(w, r)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Web search latency phases. Token refresh stays at zero when authenticating with an API key.
+const (
+	phaseTokenRefresh  = "token_refresh"
+	phaseGemini        = "gemini"
+	phaseURLResolution = "url_resolution"
+	phaseConversion    = "conversion"
+	phaseEnrichment    = "enrichment"
+	phaseQueryRewrite  = "query_rewrite"
+)
+
+// latencyPhases lists the phases in reporting order
+var latencyPhases = []string{phaseTokenRefresh, phaseQueryRewrite, phaseGemini, phaseURLResolution, phaseEnrichment, phaseConversion}
+
+// phaseTimings accumulates how long a single request spent in each phase
+type phaseTimings struct {
+	mu     sync.Mutex
+	phases map[string]time.Duration
+}
+
+type phaseTimingsKey struct{}
+
+// withPhaseTimings attaches an empty phase recorder to ctx
+func withPhaseTimings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, phaseTimingsKey{}, &phaseTimings{phases: make(map[string]time.Duration)})
+}
+
+// phaseTimingsFrom returns the phase recorder attached to ctx, or nil
+func phaseTimingsFrom(ctx context.Context) *phaseTimings {
+	t, _ := ctx.Value(phaseTimingsKey{}).(*phaseTimings)
+	return t
+}
+
+// add records time spent in a phase. It is a no-op on a nil recorder.
+func (t *phaseTimings) add(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.phases[phase] += d
+	t.mu.Unlock()
+}
+
+// get returns the time recorded for a phase so far
+func (t *phaseTimings) get(phase string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.phases[phase]
+}
+
+// trackPhase starts timing a phase for the request in ctx; call the returned
+// function when the phase ends
+func trackPhase(ctx context.Context, phase string) func() {
+	t := phaseTimingsFrom(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.add(phase, time.Since(start)) }
+}
+
+// trackConversion times response conversion, excluding the URL resolution it triggers,
+// which is reported as its own phase
+func trackConversion(ctx context.Context) func() {
+	return trackPhaseWithoutResolution(ctx, phaseConversion)
+}
+
+// trackPhaseWithoutResolution times a phase that resolves URLs, excluding the resolution
+func trackPhaseWithoutResolution(ctx context.Context, phase string) func() {
+	t := phaseTimingsFrom(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	resolvedBefore := t.get(phaseURLResolution)
+	return func() {
+		t.add(phase, time.Since(start)-(t.get(phaseURLResolution)-resolvedBefore))
+	}
+}
+
+// attrs returns the phase durations as log attributes, in milliseconds
+func (t *phaseTimings) attrs() []any {
+	var attrs []any
+	for _, phase := range latencyPhases {
+		attrs = append(attrs, phase+"_ms", t.get(phase).Milliseconds())
+	}
+	return attrs
+}
+
+// reportPhaseTimings adds the request's phase durations to the cumulative latency
+// counters and logs them at debug level, or at warn level above the slow request threshold
+func (p *Proxy) reportPhaseTimings(ctx context.Context, requestID string, total time.Duration) {
+	t := phaseTimingsFrom(ctx)
+	if t == nil {
+		return
+	}
+	for _, phase := range latencyPhases {
+		p.metrics.Add("latency."+phase+".ms_total", t.get(phase).Milliseconds())
+		p.statsd.Timing("latency."+phase, t.get(phase))
+	}
+	p.metrics.Add("latency.total.ms_total", total.Milliseconds())
+	p.statsd.Timing("latency.total", total)
+	p.metrics.Inc("latency.requests")
+
+	attrs := append([]any{"request_id", requestID, "total_ms", total.Milliseconds()}, t.attrs()...)
+	if threshold := p.cfg.SlowRequestThreshold; threshold > 0 && total.Milliseconds() >= int64(threshold) {
+		p.metrics.Inc("latency.slow_requests")
+		slog.Warn("Slow web_search request", attrs...)
+		return
+	}
+	slog.Debug("web_search timings", attrs...)
+}
@@ -6,50 +6,165 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const maxRequestBodyBytes int64 = 64 << 20 // 64MiB, virtually unreachable in normal use
+const (
+	maxRequestBodyBytes     int64 = 64 << 20 // 64MiB, virtually unreachable in normal use
+	searchRetryAfterSeconds       = 5
+)
 
 // Proxy handles HTTP requests, intercepting web_search requests
 type Proxy struct {
 	cfg           *Config
-	upstreamProxy *httputil.ReverseProxy
+	upstreamProxy *UpstreamPool
+	modelRoutes   []modelRoute
+	metrics       *Metrics
+	searchSlots   chan struct{}
+	allowedNets   []*net.IPNet
+	inFlight      atomic.Int64
+	activeSearch  atomic.Int64
+	basePath      string
+	audit         *AuditLog
+	startedAt     time.Time
+	alerter       *Alerter
+	usage         *UsageTracker
+	statsd        *StatsD
+	history       searchHistory
+	clientKeys    atomic.Pointer[[]string]          // proxy_api_keys, replaced on reload
+	clientLabels  atomic.Pointer[map[string]string] // proxy_api_key_labels, replaced on reload
+	keyBackends   atomic.Pointer[map[string]string] // proxy_api_key_backends, replaced on reload
 	geminiClient  *GeminiClient
+	backend       SearchBackend            // gemini or the engine selected by search_backend
+	backends      map[string]SearchBackend // other backends selected by requests, created on first use
+	fanOut        []SearchBackend          // search_fanout_backends, queried along with every search
+	backendsMu    sync.Mutex
+	transport     http.RoundTripper
 	urlResolver   *URLResolver
-	debug         bool
+	enricher      *pageEnricher // nil unless enrich_top_results is set
+	fetchClient   *http.Client  // fetches pages for the web_fetch tool
+	ranker        *resultRanker // nil when results pass through as returned
+	resultRules   *resultRules  // nil without result_blocked_* settings
+	batches       *batchStore
 }
 
 // NewProxy creates a new proxy instance
 func NewProxy(cfg *Config) *Proxy {
-	gc := NewGeminiClient(cfg)
+	transport, err := NewOutboundTransport(cfg)
+	if err != nil {
+		Fatal("Invalid outbound network configuration", "error", err)
+	}
+	if cfg.TLSInsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled for requests to Google")
+	}
+
+	geminiClient, err := NewGeminiClient(cfg, transport)
+	if err != nil {
+		Fatal("Failed to set up Gemini authentication", "error", err)
+	}
+	geminiClient.verifyCredentials(context.Background())
+	backend, err := newSearchBackend(cfg, transport, geminiClient)
+	if err != nil {
+		Fatal("Failed to set up the search backend", "backend", cfg.SearchBackend, "error", err)
+	}
+
+	fetchTransport := NewPageFetchTransport(cfg, transport)
 
 	p := &Proxy{
 		cfg:          cfg,
-		geminiClient: gc,
-		urlResolver:  NewURLResolver(),
-		debug:        cfg.LogLevel == "debug",
+		geminiClient: geminiClient,
+		backend:      backend,
+		backends:     make(map[string]SearchBackend),
+		transport:    transport,
+		urlResolver:  NewURLResolver(transport),
+		fetchClient:  &http.Client{Transport: fetchTransport},
+		batches:      newBatchStore(),
+		metrics:      NewMetrics(),
+		startedAt:    time.Now(),
+	}
+
+	p.clientKeys.Store(&cfg.ProxyAPIKeys)
+	p.clientLabels.Store(&cfg.ProxyAPIKeyLabels)
+	p.keyBackends.Store(&cfg.ProxyAPIKeyBackends)
+	p.enricher = newPageEnricher(cfg, fetchTransport, p.urlResolver)
+	p.ranker = newResultRanker(cfg)
+	if p.resultRules, err = newResultRules(cfg); err != nil {
+		Fatal("Invalid result filtering rules", "error", err)
+	}
+	geminiClient.onAttempt = p.recordKeyUsage
+	geminiClient.keys.quotaReached = p.keyQuotaReached
+	for _, name := range cfg.SearchFanOutBackends {
+		b, err := createSearchBackend(name, cfg, transport, geminiClient)
+		if err != nil {
+			Fatal("Failed to set up the fan-out search backends", "error", err)
+		}
+		p.fanOut = append(p.fanOut, b)
+	}
+	if chain, ok := backend.(*backendChain); ok {
+		chain.onFailure = func(name string, err error) {
+			p.metrics.Inc("search.backends." + name + ".failures")
+		}
+	}
+
+	if cfg.AlertWebhookURL != "" {
+		p.alerter = NewAlerter(cfg.AlertWebhookURL, time.Duration(cfg.AlertCooldown)*time.Second, transport)
+	}
+
+	p.usage, err = NewUsageTracker(cfg.UsageFile, cfg.DailySearchBudget, cfg.DailyTokenBudget)
+	if err != nil {
+		Fatal("Failed to load usage counters", "path", cfg.UsageFile, "error", err)
+	}
+
+	if cfg.StatsDAddress != "" {
+		p.statsd, err = NewStatsD(cfg.StatsDAddress, cfg.StatsDPrefix, cfg.StatsDTags,
+			time.Duration(cfg.StatsDFlushInterval)*time.Second, p.metrics)
+		if err != nil {
+			Fatal("Invalid statsd_address", "error", err)
+		}
 	}
 
-	// Set up reverse proxy if upstream URL is configured
-	if cfg.UpstreamURL != "" {
-		upstream, err := url.Parse(cfg.UpstreamURL)
+	if cfg.AuditLog != "" {
+		p.audit, err = NewAuditLog(cfg.AuditLog)
 		if err != nil {
-			log.Fatalf("Invalid upstream URL: %v", err)
+			Fatal("Failed to open audit log", "error", err)
 		}
+	}
+
+	if basePath := strings.Trim(cfg.BasePath, "/"); basePath != "" {
+		p.basePath = "/" + basePath
+	}
 
-		reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
-		originalDirector := reverseProxy.Director
-		reverseProxy.Director = func(req *http.Request) {
-			originalDirector(req)
-			req.Host = upstream.Host
+	// Set up reverse proxy if upstream URLs are configured
+	if len(cfg.UpstreamURLs) > 0 {
+		pool, err := NewUpstreamPool(cfg.UpstreamURLs, cfg, p.metrics)
+		if err != nil {
+			Fatal("Invalid upstream configuration", "error", err)
 		}
-		p.upstreamProxy = reverseProxy
+		p.upstreamProxy = pool
+	}
+
+	if cfg.MaxConcurrentSearches > 0 {
+		p.searchSlots = make(chan struct{}, cfg.MaxConcurrentSearches)
+	}
+
+	routes, err := newModelRoutes(cfg, p.metrics)
+	if err != nil {
+		Fatal("Invalid upstream route configuration", "error", err)
+	}
+	p.modelRoutes = routes
+
+	p.allowedNets, err = parseAllowedCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		Fatal("Invalid allowed_cidrs configuration", "error", err)
 	}
 
 	return p
@@ -57,51 +172,220 @@ func NewProxy(cfg *Config) *Proxy {
 
 // ServeHTTP implements http.Handler
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Only intercept POST requests to messages endpoint
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+
+	rw := &recoveryWriter{ResponseWriter: w}
+	defer p.recoverPanic(rw, r)
+	p.serveHTTP(rw, r)
+}
+
+// serveHTTP authenticates and routes a request
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.sourceAllowed(r) {
+		slog.Warn("Rejected request from disallowed address", "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+		p.metrics.Inc("requests.forbidden")
+		writeError(w, http.StatusForbidden, errTypePermission, "Source address not allowed")
+		return
+	}
+	// Preflight requests carry no credentials, so they are answered before authentication
+	if p.handleCORS(w, r) {
+		return
+	}
+
+	p.stripPrefix(r)
 	path := strings.TrimRight(r.URL.Path, "/")
+	// The admin API is protected by its own token rather than the client API keys
+	if isAdminRequest(path) {
+		p.handleAdmin(w, r, path)
+		return
+	}
+	if !p.authorized(r) {
+		slog.Warn("Rejected unauthenticated request", "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+		p.metrics.Inc("requests.unauthorized")
+		writeError(w, http.StatusUnauthorized, errTypeAuthentication, "Invalid or missing proxy API key")
+		return
+	}
+	backendName := p.requestedBackend(r)
+	if backendName != "" && !isSearchBackend(backendName) {
+		writeError(w, http.StatusBadRequest, errTypeInvalidRequest, fmt.Sprintf(
+			"Unknown search backend %q (expected one of %s)", backendName, strings.Join(searchBackendNames(), ", ")))
+		return
+	}
+	r = r.WithContext(withSearchBackend(withKeyLabel(r.Context(), p.keyLabel(r)), backendName))
+
+	if r.Method == http.MethodGet && strings.HasSuffix(path, "/v1/models") {
+		p.handleModels(w, r)
+		return
+	}
+	if isBatchRequest(path) {
+		p.handleBatches(w, r, path)
+		return
+	}
+	if r.Method == http.MethodGet && path == "/status" {
+		p.handleStatus(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && path == "/search" {
+		p.handleSearch(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && strings.HasSuffix(path, "/chat/completions") {
+		p.handleChatCompletions(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && strings.HasSuffix(path, "/messages/count_tokens") {
+		p.handleCountTokens(w, r)
+		return
+	}
+
+	// Only intercept POST requests to messages endpoint
 	if r.Method != http.MethodPost || !strings.HasSuffix(path, "/messages") {
-		p.proxyOrReject(w, r)
+		p.proxyOrReject(w, r, "")
 		return
 	}
 
-	// Read request body
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		var maxBytesErr *http.MaxBytesError
-		if ok := errors.As(err, &maxBytesErr); ok {
-			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
-			return
-		}
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	body, ok := readRequestBody(w, r)
+	if !ok {
 		return
 	}
-	r.Body.Close()
 
 	// Check if this is a Claude model with web_search tool
 	model := GetModel(body)
+
+	// web_fetch is answered by the upstream from pages fetched here, unless the request
+	// only needs its web_search, with no new URLs to fetch
+	if IsClaudeModel(model) && HasWebFetchTool(body) && p.upstreamFor(model) != nil &&
+		(!HasWebSearchTool(body) || len(webFetchURLs(body)) > 0) {
+		p.handleWebFetch(w, r, body, model)
+		return
+	}
+
 	if !IsClaudeModel(model) || !HasWebSearchTool(body) {
 		// Not a web_search request, proxy through
-		if p.debug {
-			log.Printf("Proxying request (no web_search): %s", r.URL.Path)
-		}
-		r.Body = io.NopCloser(bytes.NewReader(body))
-		r.ContentLength = int64(len(body))
-		p.proxyOrReject(w, r)
+		slog.Debug("Proxying request (no web_search)", "path", r.URL.Path)
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
+		return
+	}
+
+	// In tool_choice mode, only intercept when the web search is actually requested
+	if p.cfg.InterceptMode == InterceptModeToolChoice &&
+		!ToolChoiceForcesWebSearch(body) && !LastAssistantRequestedWebSearch(body) {
+		slog.Debug("Proxying request (web_search declared but not requested)", "path", r.URL.Path)
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
+		return
+	}
+
+	// Canary rollout: only a share of conversations is routed through Gemini
+	if p.cfg.InterceptPercent < 100 && ConversationBucket(body) >= p.cfg.InterceptPercent {
+		slog.Debug("Proxying request (outside intercept_percent canary)", "path", r.URL.Path)
+		p.metrics.Inc("searches.canary_skipped")
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
+		return
+	}
+
+	// Shadow mode: the client is served by the upstream; Gemini runs only for comparison
+	if p.cfg.ShadowMode {
+		go p.shadowWebSearch(model, body)
+		setRequestBody(r, body)
+		p.proxyOrReject(w, r, model)
 		return
 	}
 
+	// Gemini-served responses carry a synthetic request-id like the real API
+	requestID := setRequestID(w)
+	if err := validateAnthropicHeaders(r); err != nil {
+		writeError(w, http.StatusBadRequest, errTypeInvalidRequest, err.Error())
+		return
+	}
+	slog.Debug("Intercepted request", "request_id", requestID,
+		"anthropic_version", r.Header.Get("anthropic-version"), "anthropic_beta", AnthropicBetas(r))
+	r = p.startDebugRecord(r, requestID, body)
+	r = r.WithContext(withPhaseTimings(r.Context()))
+	start := time.Now()
+	w, closeCapture := p.captureSSE(w, requestID)
+	defer closeCapture()
+
 	// Handle web_search request
-	log.Printf("web_search detected for model %s, routing to Gemini", model)
+	if !p.acquireSearchSlot() {
+		p.rejectOverloaded(w, model)
+		writeError(w, http.StatusTooManyRequests, errTypeOverloaded, "Too many concurrent web searches, please retry shortly")
+		return
+	}
+	defer p.releaseSearchSlot()
+
+	slog.Info("web_search detected, routing to Gemini", "model", model)
 	p.handleWebSearch(w, r, body, model)
+	p.reportPhaseTimings(r.Context(), requestID, time.Since(start))
+}
+
+// acquireSearchSlot reserves one of the concurrent web_search slots without blocking.
+// It always succeeds when no concurrency limit is configured.
+func (p *Proxy) acquireSearchSlot() bool {
+	if p.searchSlots != nil {
+		select {
+		case p.searchSlots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	p.activeSearch.Add(1)
+	return true
+}
+
+// rejectOverloaded records a web search rejected by the concurrency limit and sets
+// Retry-After; the caller writes the error body in its API's format
+func (p *Proxy) rejectOverloaded(w http.ResponseWriter, model string) {
+	slog.Warn("web_search rejected: concurrency limit reached", "model", model, "in_flight", cap(p.searchSlots))
+	p.metrics.Inc("searches.rejected")
+	w.Header().Set("Retry-After", strconv.Itoa(searchRetryAfterSeconds))
+}
+
+// releaseSearchSlot frees a slot reserved by acquireSearchSlot
+func (p *Proxy) releaseSearchSlot() {
+	p.activeSearch.Add(-1)
+	if p.searchSlots != nil {
+		<-p.searchSlots
+	}
+}
+
+// InFlight returns the number of requests currently being handled
+func (p *Proxy) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+// ActiveSearches returns the number of web searches currently in progress
+func (p *Proxy) ActiveSearches() int64 {
+	return p.activeSearch.Load()
+}
+
+// readRequestBody reads the size-limited request body, writing an error response on failure
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if ok := errors.As(err, &maxBytesErr); ok {
+			writeError(w, http.StatusRequestEntityTooLarge, errTypeRequestTooLarge, "Request body too large")
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, errTypeInvalidRequest, "Failed to read request body")
+		return nil, false
+	}
+	r.Body.Close()
+	return body, true
 }
 
-// proxyOrReject either proxies the request or returns an error if no upstream
-func (p *Proxy) proxyOrReject(w http.ResponseWriter, r *http.Request) {
-	if p.upstreamProxy != nil {
-		p.upstreamProxy.ServeHTTP(w, r)
+// proxyOrReject either proxies the request to the upstream for the model (the default
+// upstream when model is empty) or returns an error if no upstream
+func (p *Proxy) proxyOrReject(w http.ResponseWriter, r *http.Request, model string) {
+	if upstream := p.upstreamFor(model); upstream != nil {
+		upstream.ServeHTTP(w, r)
 	} else {
-		http.Error(w, "No upstream configured and request is not a web_search request", http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, errTypeAPI, "No upstream configured and request is not a web_search request")
 	}
 }
 
@@ -109,63 +393,222 @@ func (p *Proxy) proxyOrReject(w http.ResponseWriter, r *http.Request) {
 func (p *Proxy) handleWebSearch(w http.ResponseWriter, r *http.Request, body []byte, model string) {
 	ctx := r.Context()
 
-	if p.debug {
+	if debugEnabled() {
 		query := ExtractUserQuery(body)
 		sum := sha256.Sum256([]byte(query))
-		log.Printf("Executing web search with full conversation history (last_query_bytes=%d, last_query_sha256=%s)",
-			len(query), hex.EncodeToString(sum[:]))
+		slog.Debug("Executing web search with full conversation history",
+			"last_query_bytes", len(query), "last_query_sha256", hex.EncodeToString(sum[:]))
+	}
+
+	// Enforce the tool's max_uses against searches already performed in this conversation
+	if maxUses := WebSearchMaxUses(body); maxUses > 0 && CountWebSearchResults(body) >= maxUses {
+		slog.Info("web_search max_uses reached, returning max_uses_exceeded", "max_uses", maxUses)
+		p.writeToolError(w, model, body, toolErrMaxUsesExceeded)
+		return
+	}
+
+	// Once the daily budget is spent, answer without Gemini rather than burning paid quota
+	if p.usage.Exhausted() {
+		p.metrics.Inc("searches.budget_exhausted")
+		if p.fallbackEnabled(model) {
+			p.forwardWithoutWebSearch(w, r, body, model)
+			return
+		}
+		p.writeToolError(w, model, body, toolErrTooManyRequests)
+		return
+	}
+
+	// Orchestration mode: Gemini only searches, the upstream Claude writes the answer
+	if p.cfg.WebSearchMode == WebSearchModeOrchestrate && p.upstreamFor(model) != nil {
+		p.orchestrateWebSearch(w, r, body, model)
+		return
+	}
+
+	// Streaming requests open the SSE stream before the search so the client
+	// receives message_start and keep-alive pings while Gemini is working.
+	// With fallback enabled the stream is deferred until the search succeeds,
+	// since a failed search must still be able to forward upstream.
+	streaming := IsStreamingRequest(body)
+	if streaming && !p.fallbackEnabled(model) {
+		p.streamWebSearch(ctx, w, model, body)
+		return
 	}
 
 	// Execute Gemini web search with full Claude payload (conversation history)
-	geminiResp, err := p.geminiClient.ExecuteWebSearch(ctx, body)
+	geminiResp, err := p.executeSearch(ctx, body)
 	if err != nil {
-		log.Printf("Gemini web search failed: %v", err)
-		http.Error(w, "Web search temporarily unavailable", http.StatusBadGateway)
+		slog.Error("Web search failed", "backend", p.backendName(ctx), "error", err)
+		if p.fallbackEnabled(model) {
+			p.forwardWithoutWebSearch(w, r, body, model)
+			return
+		}
+		writeError(w, http.StatusBadGateway, errTypeAPI, "Web search temporarily unavailable")
 		return
 	}
 
-	if p.debug {
-		log.Printf("Gemini response received, converting to Claude format with URL resolution and citations")
-	}
+	slog.Debug("Gemini response received, converting to Claude format with URL resolution and citations")
 
-	// Check if streaming
-	if IsStreamingRequest(body) {
+	if streaming {
 		p.writeSSEResponse(ctx, w, model, geminiResp)
 	} else {
-		p.writeNonStreamResponse(ctx, w, model, geminiResp)
+		p.writeNonStreamResponse(w, r, model, geminiResp)
 	}
 }
 
-// writeNonStreamResponse writes a non-streaming Claude response
-func (p *Proxy) writeNonStreamResponse(ctx context.Context, w http.ResponseWriter, model string, geminiResp []byte) {
-	response := ConvertToClaudeNonStream(ctx, model, geminiResp, p.urlResolver)
+// writeToolError answers a web_search request with a web_search_tool_result error
+// instead of performing a search
+func (p *Proxy) writeToolError(w http.ResponseWriter, model string, body []byte, errorCode string) {
+	query := ExtractUserQuery(body)
+	if !IsStreamingRequest(body) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(ConvertToolErrorNonStream(model, query, errorCode)))
+		return
+	}
+
+	sw := newSSEWriter(w)
+	sw.Send(MessageStartEvent(NewMessageID(), model, 0))
+	for _, event := range ConvertToolErrorSSEEvents(query, errorCode) {
+		sw.Send(event)
+	}
+}
+
+// fallbackEnabled reports whether failed web searches for the model should be forwarded upstream
+func (p *Proxy) fallbackEnabled(model string) bool {
+	return p.cfg.WebSearchFallback && p.upstreamFor(model) != nil
+}
+
+// forwardWithoutWebSearch strips the web_search tool from the payload and forwards
+// the request upstream so the model can still answer without search
+func (p *Proxy) forwardWithoutWebSearch(w http.ResponseWriter, r *http.Request, body []byte, model string) {
+	stripped, err := StripServerTools(body)
+	if err != nil {
+		slog.Error("Failed to strip web_search tool for fallback", "error", err)
+		writeError(w, http.StatusBadGateway, errTypeAPI, "Web search temporarily unavailable")
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(response))
+	slog.Warn("Falling back to upstream without web_search", "path", r.URL.Path)
+	setRequestBody(r, stripped)
+	w.Header().Del("request-id") // the upstream supplies its own
+	p.upstreamFor(model).ServeHTTP(w, r)
 }
 
-// writeSSEResponse writes a streaming SSE Claude response
+// setRequestBody replaces the request body with a rewritten payload
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// executeSearch runs the web search for a Claude payload and applies the request's
+// domain restrictions to the results
+func (p *Proxy) executeSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	if p.usage.Exhausted() {
+		p.metrics.Inc("searches.budget_exhausted")
+		return nil, errBudgetExhausted
+	}
+
+	start := time.Now()
+	backend, err := p.searchBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.QueryRewrite && p.geminiClient.keys.size() > 0 {
+		ctx = p.rewriteQueries(ctx, claudePayload)
+	}
+	stopGemini := trackPhase(ctx, phaseGemini)
+	var geminiResp []byte
+	if len(p.fanOut) > 0 {
+		geminiResp, err = p.fanOutSearch(ctx, backend, claudePayload)
+	} else {
+		geminiResp, err = backend.ExecuteSearch(ctx, claudePayload)
+	}
+	stopGemini()
+	if err != nil {
+		p.auditSearch(backend, claudePayload, nil, err, start)
+		return nil, err
+	}
+	p.usage.Record(geminiResp)
+
+	if p.resultRules != nil {
+		var removed int
+		geminiResp, removed = p.resultRules.apply(ctx, geminiResp, p.urlResolver)
+		if removed > 0 {
+			p.metrics.Add("searches.results_blocked", int64(removed))
+		}
+	}
+	if filter := ExtractDomainFilter(claudePayload); filter != nil {
+		geminiResp = FilterGroundingChunks(ctx, geminiResp, filter, p.urlResolver)
+	}
+	if p.ranker != nil {
+		var duplicates int
+		geminiResp, duplicates = p.ranker.rank(ctx, geminiResp, p.urlResolver)
+		if duplicates > 0 {
+			p.metrics.Add("searches.duplicates_removed", int64(duplicates))
+		}
+	}
+	if p.enricher != nil {
+		stopEnrichment := trackPhaseWithoutResolution(ctx, phaseEnrichment)
+		geminiResp = p.enricher.enrich(ctx, geminiResp)
+		stopEnrichment()
+	}
+	p.auditSearch(backend, claudePayload, geminiResp, nil, start)
+	return geminiResp, nil
+}
+
+// writeNonStreamResponse writes a non-streaming Claude response
+func (p *Proxy) writeNonStreamResponse(w http.ResponseWriter, r *http.Request, model string, geminiResp []byte) {
+	stopConversion := trackConversion(r.Context())
+	response := ConvertToClaudeNonStream(r.Context(), model, geminiResp, p.urlResolver)
+	stopConversion()
+	debugRecordFrom(r.Context()).write("claude_response.json", []byte(response))
+	p.writeJSON(w, r, http.StatusOK, []byte(response))
+}
+
+// writeSSEResponse writes a streaming SSE Claude response for a completed search
 func (p *Proxy) writeSSEResponse(ctx context.Context, w http.ResponseWriter, model string, geminiResp []byte) {
+	stopConversion := trackConversion(ctx)
 	events := ConvertToClaudeSSEStream(ctx, model, geminiResp, p.urlResolver)
+	stopConversion()
+	debugRecordFrom(ctx).write("claude_response.sse", []byte(strings.Join(events, "")))
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
-	w.WriteHeader(http.StatusOK)
+	sw := newSSEWriter(w)
+	for _, event := range events {
+		sw.Send(event)
+	}
+}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		// Fallback: write all at once
-		for _, event := range events {
-			w.Write([]byte(event))
-		}
+// streamWebSearch executes the web search and writes a streaming SSE Claude response,
+// emitting ping events while the Gemini call and URL resolution are in flight
+func (p *Proxy) streamWebSearch(ctx context.Context, w http.ResponseWriter, model string, body []byte) {
+	sw := newSSEWriter(w)
+	messageStart := MessageStartEvent(NewMessageID(), model, 0)
+	sw.Send(messageStart)
+	stopPing := sw.StartPing(time.Duration(p.cfg.SSEPingInterval) * time.Second)
+
+	var events []string
+	geminiResp, err := p.executeSearch(ctx, body)
+	if err == nil {
+		slog.Debug("Gemini response received, converting to Claude format with URL resolution and citations")
+		stopConversion := trackConversion(ctx)
+		events = ConvertToClaudeSSEEvents(ctx, geminiResp, p.urlResolver)
+		stopConversion()
+	}
+	stopPing()
+
+	if err != nil {
+		// Headers are already sent, so report the failure in-stream
+		slog.Error("Web search failed", "backend", p.backendName(ctx), "error", err)
+		sw.Send(errorEvent(errTypeAPI, "Web search temporarily unavailable"))
 		return
 	}
 
+	debugRecordFrom(ctx).write("claude_response.sse", []byte(messageStart+strings.Join(events, "")))
 	for _, event := range events {
-		w.Write([]byte(event))
-		flusher.Flush()
+		sw.Send(event)
 	}
 }
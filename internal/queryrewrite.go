@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// maxRewrittenQueries caps the queries the rewriting step may return
+	maxRewrittenQueries = 3
+	// queryRewriteTimeout bounds the rewriting step, after which the search runs as is
+	queryRewriteTimeout = 10 * time.Second
+)
+
+// queryRewriteInstruction is the system instruction of the query rewriting request
+const queryRewriteInstruction = "You turn a conversation into web search queries. Reply with a JSON array of " +
+	"1 to 3 short, focused search queries that find the information needed to answer the last user message. " +
+	"Use the terms a search engine needs, such as names, versions and error messages, and resolve what the " +
+	"message refers to from the earlier conversation. Do not answer the question."
+
+type searchQueriesKey struct{}
+
+// withSearchQueries makes searches under ctx run the given queries instead of the last
+// user message
+func withSearchQueries(ctx context.Context, queries []string) context.Context {
+	if len(queries) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, searchQueriesKey{}, queries)
+}
+
+// searchQueriesFrom returns the rewritten queries attached to ctx, or nil
+func searchQueriesFrom(ctx context.Context) []string {
+	queries, _ := ctx.Value(searchQueriesKey{}).([]string)
+	return queries
+}
+
+// userQuery returns what a search engine taking a single query should search for: the
+// first rewritten query when there is one, otherwise the last user message
+func userQuery(ctx context.Context, claudePayload []byte) string {
+	if queries := searchQueriesFrom(ctx); len(queries) > 0 {
+		return queries[0]
+	}
+	return strings.TrimSpace(ExtractUserQuery(claudePayload))
+}
+
+type queryRewriteKey struct{}
+
+// rewritingQueries reports whether the Gemini request under ctx rewrites the search
+// queries rather than searching
+func rewritingQueries(ctx context.Context) bool {
+	return ctx.Value(queryRewriteKey{}) != nil
+}
+
+// rewriteQueries asks the search model for focused search queries for the conversation
+// and attaches them to ctx. When that fails, ctx is returned as is and the search runs
+// with the last user message.
+func (p *Proxy) rewriteQueries(ctx context.Context, claudePayload []byte) context.Context {
+	defer trackPhase(ctx, phaseQueryRewrite)()
+
+	rewriteCtx, cancel := context.WithTimeout(context.WithValue(ctx, queryRewriteKey{}, true), queryRewriteTimeout)
+	defer cancel()
+	resp, err := p.geminiClient.ExecuteSearch(rewriteCtx, claudePayload)
+	if err != nil {
+		p.metrics.Inc("query_rewrite.errors")
+		slog.Warn("Query rewriting failed, searching for the user message", "error", err)
+		return ctx
+	}
+	queries := parseRewrittenQueries(resp)
+	if len(queries) == 0 {
+		p.metrics.Inc("query_rewrite.errors")
+		slog.Warn("Query rewriting returned no queries, searching for the user message")
+		return ctx
+	}
+	p.metrics.Inc("query_rewrite.requests")
+	slog.Debug("Rewrote search queries", "queries", queries)
+	return withSearchQueries(ctx, queries)
+}
+
+// buildRewriteRequest constructs the request asking Gemini for the search queries of a
+// conversation, as a JSON array of strings
+func (gc *GeminiClient) buildRewriteRequest(claudePayload []byte) (string, error) {
+	contents, err := TransformMessages(claudePayload)
+	if err != nil {
+		return "", fmt.Errorf("failed to transform messages: %w", err)
+	}
+	if len(contents) == 0 {
+		query := ExtractUserQuery(claudePayload)
+		if query == "" {
+			return "", fmt.Errorf("no messages found in payload")
+		}
+		contents = []GeminiContent{{Role: "user", Parts: []GeminiPart{{Text: query}}}}
+	}
+	contentsJSON, err := json.Marshal(contents)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal contents: %w", err)
+	}
+
+	req := `{"contents":[],"systemInstruction":{"parts":[{"text":""}]},"generationConfig":` +
+		`{"temperature":0,"responseMimeType":"application/json","responseSchema":{"type":"ARRAY","items":{"type":"STRING"}}}}`
+	req, _ = sjson.SetRaw(req, "contents", string(contentsJSON))
+	req, _ = sjson.Set(req, "systemInstruction.parts.0.text", queryRewriteInstruction)
+	return req, nil
+}
+
+// parseRewrittenQueries reads the queries from the response to a rewriting request,
+// dropping empty and repeated ones
+func parseRewrittenQueries(geminiResp []byte) []string {
+	var text strings.Builder
+	for _, part := range gjson.GetBytes(geminiResp, "candidates.0.content.parts").Array() {
+		text.WriteString(part.Get("text").String())
+	}
+	var raw []string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text.String())), &raw); err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var queries []string
+	for _, q := range raw {
+		q = strings.Join(strings.Fields(q), " ")
+		if q == "" || seen[strings.ToLower(q)] {
+			continue
+		}
+		seen[strings.ToLower(q)] = true
+		queries = append(queries, q)
+		if len(queries) == maxRewrittenQueries {
+			break
+		}
+	}
+	return queries
+}
+
+// queriesHint asks the grounded search to run the rewritten queries
+func queriesHint(queries []string) string {
+	quoted := make([]string, len(queries))
+	for i, q := range queries {
+		quoted[i] = strconv.Quote(q)
+	}
+	return "Search the web with these queries: " + strings.Join(quoted, ", ")
+}
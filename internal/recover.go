@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// recoveryWriter records whether the response has started, so a recovered panic
+// knows whether an error response can still be sent
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (rw *recoveryWriter) WriteHeader(status int) {
+	rw.started = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recoveryWriter) Write(b []byte) (int, error) {
+	rw.started = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (rw *recoveryWriter) Flush() {
+	rw.started = true
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (rw *recoveryWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// recoverPanic turns a panic in a request handler into a logged stack trace, a crash
+// counter increment and a structured api_error, instead of a dropped connection.
+// It must be deferred directly by the handler.
+func (p *Proxy) recoverPanic(rw *recoveryWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+		// Deliberate aborts (e.g. by the reverse proxy) keep their semantics
+		panic(rec)
+	}
+
+	p.metrics.Inc("requests.panics")
+	slog.Error("Panic while handling request", "method", r.Method, "path", r.URL.Path,
+		"panic", rec, "stack", string(debug.Stack()))
+
+	const message = "Internal error while processing the request"
+	switch {
+	case !rw.started:
+		writeError(rw, http.StatusInternalServerError, errTypeAPI, message)
+	case strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream"):
+		// The stream is already open, so report the failure in-stream
+		rw.Write([]byte(errorEvent(errTypeAPI, message)))
+		rw.Flush()
+	}
+}
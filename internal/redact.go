@@ -0,0 +1,12 @@
+package internal
+
+import "regexp"
+
+// urlSecretParam matches the query parameters of a URL that carry credentials
+var urlSecretParam = regexp.MustCompile(`(?i)([?&](?:key|api_key|apikey|access_token|token|sig|signature)=)[^&\s"']+`)
+
+// redactURLSecrets replaces the credentials in the URLs quoted by s, such as the API key
+// of a request in a transport error, with <redacted>
+func redactURLSecrets(s string) string {
+	return urlSecretParam.ReplaceAllString(s, "${1}<redacted>")
+}
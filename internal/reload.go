@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Reload re-reads the config file and environment and applies the settings that can
+// change at runtime: gemini_api_key(s), proxy_api_keys, the key labels and backends and
+// log_level. Everything else takes effect on the next restart. On error the running
+// configuration is kept.
+func (p *Proxy) Reload() error {
+	cfg, err := LoadConfig(p.cfg.path)
+	if err != nil {
+		return err
+	}
+	if p.backend.Name() == SearchBackendGemini && cfg.GeminiAuth == GeminiAuthAPIKey && len(cfg.GeminiAPIKeys) == 0 {
+		return fmt.Errorf("gemini_api_key is not set")
+	}
+
+	previous := p.geminiClient.KeyID()
+	p.geminiClient.SetAPIKeys(cfg.GeminiAPIKeys, cfg.GeminiKeyLabels)
+	p.clientKeys.Store(&cfg.ProxyAPIKeys)
+	p.clientLabels.Store(&cfg.ProxyAPIKeyLabels)
+	p.keyBackends.Store(&cfg.ProxyAPIKeyBackends)
+	setLogLevel(cfg.LogLevel)
+
+	slog.Info("Configuration reloaded", "path", p.cfg.path, "previous_key", previous, "key", p.geminiClient.KeyID(),
+		"gemini_api_keys", len(cfg.GeminiAPIKeys), "proxy_api_keys", len(cfg.ProxyAPIKeys), "log_level", cfg.LogLevel)
+	return nil
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReplaySSE serves a captured SSE stream to every POST .../messages request on addr,
+// sending one event per delay, so a client such as Claude Code can be pointed at it to
+// reproduce rendering issues. Other requests get 404.
+func ReplaySSE(addr, capturePath string, delay time.Duration) error {
+	data, err := os.ReadFile(capturePath)
+	if err != nil {
+		return err
+	}
+	var events []string
+	for _, event := range strings.SplitAfter(string(data), "\n\n") {
+		if strings.TrimSpace(event) != "" {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("no SSE events found in %s", capturePath)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/messages") {
+			writeError(w, http.StatusNotFound, errTypeNotFound, "Replay server only answers POST /v1/messages")
+			return
+		}
+		slog.Info("Replaying captured stream", "path", capturePath, "events", len(events))
+
+		sw := newSSEWriter(w)
+		for i, event := range events {
+			if i > 0 && delay > 0 {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+			sw.Send(event)
+		}
+	})
+
+	slog.Info("Replay server listening", "addr", addr, "events", len(events))
+	return http.ListenAndServe(addr, handler)
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tidwall/gjson"
+)
+
+// Result rankings
+const (
+	ResultRankingNone    = "none"
+	ResultRankingDomain  = "domain"
+	ResultRankingRecency = "recency"
+)
+
+const (
+	// pageAgeLayout is the date format of web_search_result page_age
+	pageAgeLayout = "January 2, 2006"
+	// minTitleWords keeps short titles out of deduplication: Gemini titles its chunks with
+	// the site's domain, which many distinct results share
+	minTitleWords = 3
+)
+
+// resultRanker is the post-search stage that drops duplicate results, orders them by
+// domain quality or recency and keeps at most maxCount of them
+type resultRanker struct {
+	dedupe    bool
+	ranking   string
+	preferred []string
+	demoted   []string
+	maxCount  int
+}
+
+// newResultRanker creates the ranking stage from result_dedupe, result_ranking,
+// preferred_domains, demoted_domains and result_max_count, or returns nil when it has
+// nothing to do
+func newResultRanker(cfg *Config) *resultRanker {
+	if !cfg.ResultDedupe && cfg.ResultRanking == ResultRankingNone && cfg.ResultMaxCount == 0 {
+		return nil
+	}
+	rr := &resultRanker{dedupe: cfg.ResultDedupe, ranking: cfg.ResultRanking, maxCount: cfg.ResultMaxCount}
+	for _, d := range cfg.PreferredDomains {
+		rr.preferred = append(rr.preferred, normalizeDomain(d))
+	}
+	for _, d := range cfg.DemotedDomains {
+		rr.demoted = append(rr.demoted, normalizeDomain(d))
+	}
+	return rr
+}
+
+// rank applies the stage to the grounding chunks of a Gemini response. Grounding supports
+// of a dropped duplicate move to the result it duplicates.
+func (rr *resultRanker) rank(ctx context.Context, geminiResp []byte, resolver *URLResolver) ([]byte, int) {
+	prefix := "candidates.0.groundingMetadata"
+	if gjson.GetBytes(geminiResp, "response."+prefix).Exists() {
+		prefix = "response." + prefix
+	}
+	chunks := gjson.GetBytes(geminiResp, prefix+".groundingChunks").Array()
+	if len(chunks) == 0 {
+		return geminiResp, 0
+	}
+
+	urls := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		urls[i] = chunk.Get("web.uri").String()
+	}
+	if resolver != nil {
+		urls = resolver.ResolveURLs(ctx, urls)
+	}
+
+	// Indices of the chunks kept, and of the kept chunk each duplicate repeats
+	var order []int
+	duplicateOf := make(map[int]int)
+	byURL := make(map[string]int)
+	byTitle := make(map[string]int)
+	for i, chunk := range chunks {
+		if rr.dedupe && chunk.Get("web").Exists() {
+			canonical := canonicalURL(urls[i])
+			title := titleKey(chunk.Get("web.title").String())
+			if first, ok := byURL[canonical]; ok {
+				duplicateOf[i] = first
+				continue
+			}
+			if first, ok := byTitle[title]; ok && title != "" {
+				duplicateOf[i] = first
+				continue
+			}
+			byURL[canonical] = i
+			if title != "" {
+				byTitle[title] = i
+			}
+		}
+		order = append(order, i)
+	}
+
+	switch rr.ranking {
+	case ResultRankingDomain:
+		sort.SliceStable(order, func(a, b int) bool {
+			return rr.domainRank(urls[order[a]]) < rr.domainRank(urls[order[b]])
+		})
+	case ResultRankingRecency:
+		sort.SliceStable(order, func(a, b int) bool {
+			return publishedAt(chunks[order[a]]).After(publishedAt(chunks[order[b]]))
+		})
+	}
+	if rr.maxCount > 0 && len(order) > rr.maxCount {
+		order = order[:rr.maxCount]
+	}
+
+	remap := make(map[int64]int64)
+	kept := make([]string, len(order))
+	changed := len(order) != len(chunks)
+	for newIdx, oldIdx := range order {
+		remap[int64(oldIdx)] = int64(newIdx)
+		kept[newIdx] = chunks[oldIdx].Raw
+		changed = changed || newIdx != oldIdx
+	}
+	if !changed {
+		return geminiResp, 0
+	}
+	for dup, first := range duplicateOf {
+		if newIdx, ok := remap[int64(first)]; ok {
+			remap[int64(dup)] = newIdx
+		}
+	}
+	return setGroundingChunks(geminiResp, prefix, kept, remap), len(duplicateOf)
+}
+
+// domainRank orders results from preferred domains first and from demoted domains last
+func (rr *resultRanker) domainRank(rawURL string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return 1
+	}
+	for _, entry := range rr.preferred {
+		if matchDomain(u, entry) {
+			return 0
+		}
+	}
+	for _, entry := range rr.demoted {
+		if matchDomain(u, entry) {
+			return 2
+		}
+	}
+	return 1
+}
+
+// publishedAt returns the date of a result from its page age, or the zero time for
+// undated results, which sort after dated ones
+func publishedAt(chunk gjson.Result) time.Time {
+	t, _ := time.Parse(pageAgeLayout, chunk.Get("web.pageAge").String())
+	return t
+}
+
+// canonicalURL reduces a URL to what identifies the page: without scheme, "www.",
+// fragment, trailing slash and tracking parameters
+func canonicalURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	query := u.Query()
+	for name := range query {
+		if strings.HasPrefix(name, "utm_") || name == "fbclid" || name == "gclid" {
+			query.Del(name)
+		}
+	}
+	canonical := strings.TrimPrefix(strings.ToLower(u.Host), "www.") + strings.TrimSuffix(u.EscapedPath(), "/")
+	if encoded := query.Encode(); encoded != "" {
+		canonical += "?" + encoded
+	}
+	return canonical
+}
+
+// titleKey normalizes a result title for comparison, or returns "" for titles too short to
+// tell results apart
+func titleKey(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) < minTitleWords {
+		return ""
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/tidwall/gjson"
+)
+
+// resultRules drops search results from blocked domains and results whose URL or title
+// matches a blocked pattern, whatever the request asks for
+type resultRules struct {
+	domains       []string
+	urlPatterns   []*regexp.Regexp
+	titlePatterns []*regexp.Regexp
+}
+
+// validateResultRules checks that the result_blocked_*_patterns entries compile
+func validateResultRules(cfg *Config) error {
+	_, err := newResultRules(cfg)
+	return err
+}
+
+// newResultRules compiles result_blocked_domains, result_blocked_url_patterns and
+// result_blocked_title_patterns, or returns nil when none is set
+func newResultRules(cfg *Config) (*resultRules, error) {
+	if len(cfg.ResultBlockedDomains) == 0 && len(cfg.ResultBlockedURLPatterns) == 0 &&
+		len(cfg.ResultBlockedTitlePatterns) == 0 {
+		return nil, nil
+	}
+	rules := &resultRules{}
+	for _, d := range cfg.ResultBlockedDomains {
+		rules.domains = append(rules.domains, normalizeDomain(d))
+	}
+	var err error
+	if rules.urlPatterns, err = compilePatterns("result_blocked_url_patterns", cfg.ResultBlockedURLPatterns); err != nil {
+		return nil, err
+	}
+	if rules.titlePatterns, err = compilePatterns("result_blocked_title_patterns", cfg.ResultBlockedTitlePatterns); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compilePatterns compiles the regular expressions of a config setting
+func compilePatterns(setting string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: invalid pattern %q: %w", setting, i, p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// apply removes the grounding chunks the rules block and returns how many it removed
+func (rules *resultRules) apply(ctx context.Context, geminiResp []byte, resolver *URLResolver) ([]byte, int) {
+	removed := 0
+	geminiResp = filterGroundingChunks(ctx, geminiResp, resolver, func(chunk gjson.Result, url string) bool {
+		if !chunk.Get("web").Exists() || rules.allows(url, chunk.Get("web.title").String()) {
+			return true
+		}
+		removed++
+		return false
+	})
+	return geminiResp, removed
+}
+
+// allows reports whether a result passes the rules
+func (rules *resultRules) allows(rawURL, title string) bool {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		for _, entry := range rules.domains {
+			if matchDomain(u, entry) {
+				return false
+			}
+		}
+	}
+	for _, re := range rules.urlPatterns {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	for _, re := range rules.titlePatterns {
+		if re.MatchString(title) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// retryTransport retries upstream requests that failed before the upstream did any work:
+// refused connections, and 502/503 responses to idempotent requests. A 502 to a POST may
+// come after the model call ran, so retrying it could run and bill the request twice.
+// Requests are only retried when their body can be replayed; each attempt sends a copy,
+// leaving the caller's request untouched.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	backoff time.Duration
+	metrics *Metrics
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := t.backoff
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.retries || !retryableUpstreamFailure(req.Method, resp, err) {
+			return resp, err
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			attemptReq.Body = body
+		}
+
+		if resp != nil {
+			slog.Warn("Upstream request failed, retrying", "upstream", req.URL.Host, "status", resp.StatusCode, "delay", delay, "attempt", attempt+1, "retries", t.retries)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			slog.Warn("Upstream request failed, retrying", "upstream", req.URL.Host, "error", err, "delay", delay, "attempt", attempt+1, "retries", t.retries)
+		}
+		t.metrics.Inc("upstream.retries")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryableUpstreamFailure reports whether an upstream attempt failed in a way that is
+// safe to retry: the connection was refused, or the upstream answered 502/503 to an
+// idempotent request
+func retryableUpstreamFailure(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	return idempotentMethod(method) &&
+		(resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable)
+}
+
+// idempotentMethod reports whether sending a request with method twice has the same
+// effect as sending it once
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
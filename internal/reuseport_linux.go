@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package internal
+
+// soReusePort is SO_REUSEPORT, which the syscall package omits on some Linux architectures
+const soReusePort = 0xf
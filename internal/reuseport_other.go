@@ -0,0 +1,13 @@
+//go:build !((linux && !(mips || mipsle || mips64 || mips64le)) || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl is unavailable on platforms without SO_REUSEPORT
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("listen_reuse_port is not supported on this platform")
+}
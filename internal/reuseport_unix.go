@@ -0,0 +1,18 @@
+//go:build (linux && !(mips || mipsle || mips64 || mips64le)) || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package internal
+
+import "syscall"
+
+// reusePortControl sets SO_REUSEPORT on a listening socket so that a second proxy
+// process can bind the same address while the first one drains
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
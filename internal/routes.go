@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UpstreamRoute sends requests whose model matches a pattern to dedicated upstreams
+type UpstreamRoute struct {
+	// Regular expression matched against the request's model field
+	Model string `yaml:"model"`
+
+	// Upstream URL for matching models
+	UpstreamURL string `yaml:"upstream_url"`
+
+	// Upstream URLs in failover priority order (overrides UpstreamURL when set)
+	UpstreamURLs []string `yaml:"upstream_urls"`
+}
+
+// Targets returns the route's upstream URLs in priority order
+func (rt UpstreamRoute) Targets() []string {
+	if len(rt.UpstreamURLs) > 0 {
+		return rt.UpstreamURLs
+	}
+	if rt.UpstreamURL != "" {
+		return []string{rt.UpstreamURL}
+	}
+	return nil
+}
+
+// modelRoute is a compiled UpstreamRoute
+type modelRoute struct {
+	pattern  *regexp.Regexp
+	upstream *UpstreamPool
+}
+
+// validateUpstreamRoutes checks that every route has a valid pattern and at least one upstream
+func validateUpstreamRoutes(routes []UpstreamRoute) error {
+	for i, rt := range routes {
+		if _, err := regexp.Compile(rt.Model); err != nil {
+			return fmt.Errorf("upstream_routes[%d]: invalid model pattern %q: %w", i, rt.Model, err)
+		}
+		if len(rt.Targets()) == 0 {
+			return fmt.Errorf("upstream_routes[%d]: no upstream_url configured", i)
+		}
+	}
+	return nil
+}
+
+// newModelRoutes builds an upstream pool for each configured model route
+func newModelRoutes(cfg *Config, metrics *Metrics) ([]modelRoute, error) {
+	routes := make([]modelRoute, 0, len(cfg.UpstreamRoutes))
+	for i, rt := range cfg.UpstreamRoutes {
+		pattern, err := regexp.Compile(rt.Model)
+		if err != nil {
+			return nil, fmt.Errorf("upstream_routes[%d]: %w", i, err)
+		}
+		pool, err := NewUpstreamPool(rt.Targets(), cfg, metrics)
+		if err != nil {
+			return nil, fmt.Errorf("upstream_routes[%d]: %w", i, err)
+		}
+		routes = append(routes, modelRoute{pattern: pattern, upstream: pool})
+	}
+	return routes, nil
+}
+
+// upstreamFor returns the upstream for a model: the first route whose pattern matches,
+// otherwise the default upstream (nil if none is configured)
+func (p *Proxy) upstreamFor(model string) *UpstreamPool {
+	if model != "" {
+		for _, rt := range p.modelRoutes {
+			if rt.pattern.MatchString(model) {
+				return rt.upstream
+			}
+		}
+	}
+	return p.upstreamProxy
+}
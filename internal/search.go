@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// SearchResult is a single result returned by the /search endpoint
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+	PageAge string `json:"page_age,omitempty"`
+}
+
+// SearchResponse is the body returned by the /search endpoint
+type SearchResponse struct {
+	Query         string         `json:"query"`
+	SearchQueries []string       `json:"search_queries"`
+	Answer        string         `json:"answer"`
+	Results       []SearchResult `json:"results"`
+	Citations     []*Citation    `json:"citations"`
+}
+
+// handleSearch serves POST /search {"query": "..."}, running the Gemini googleSearch
+// pipeline and returning structured results for scripts and non-Claude tools
+func (p *Proxy) handleSearch(w http.ResponseWriter, r *http.Request) {
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(gjson.GetBytes(body, "query").String())
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errTypeInvalidRequest, "query is required")
+		return
+	}
+
+	if !p.acquireSearchSlot() {
+		p.rejectOverloaded(w, "")
+		writeError(w, http.StatusTooManyRequests, errTypeOverloaded, "Too many concurrent web searches, please retry shortly")
+		return
+	}
+	defer p.releaseSearchSlot()
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": query}},
+	})
+
+	ctx := r.Context()
+	geminiResp, err := p.executeSearch(ctx, payload)
+	if err != nil {
+		slog.Error("Web search failed", "backend", p.backendName(ctx), "error", err)
+		writeError(w, http.StatusBadGateway, errTypeAPI, "Web search temporarily unavailable")
+		return
+	}
+
+	resp := BuildSearchResponse(query, geminiResp, extractWebSearchResultsWithResolve(ctx, extractGroundingMetadata(geminiResp), p.urlResolver))
+	if maxResults := int(gjson.GetBytes(body, "max_results").Int()); maxResults > 0 && len(resp.Results) > maxResults {
+		resp.Results = resp.Results[:maxResults]
+	}
+
+	respJSON, _ := json.Marshal(resp)
+	p.writeJSON(w, r, http.StatusOK, respJSON)
+}
+
+// BuildSearchResponse builds the structured /search response from a Gemini response and its
+// resolved web search results. Each result's snippet is the answer text grounded in it, or
+// the page content the search engine returned.
+func BuildSearchResponse(query string, geminiResp []byte, results []map[string]interface{}) *SearchResponse {
+	resp := &SearchResponse{
+		Query:         query,
+		SearchQueries: []string{},
+		Answer:        extractTextContent(geminiResp),
+		Results:       make([]SearchResult, len(results)),
+		Citations:     []*Citation{},
+	}
+
+	for _, q := range extractGroundingMetadata(geminiResp).Get("webSearchQueries").Array() {
+		resp.SearchQueries = append(resp.SearchQueries, q.String())
+	}
+
+	snippets := make([][]string, len(results))
+	for _, support := range extractGroundingSupports(geminiResp).Array() {
+		text := support.Get("segment.text").String()
+		for _, idx := range support.Get("groundingChunkIndices").Array() {
+			if i := int(idx.Int()); text != "" && i >= 0 && i < len(results) {
+				snippets[i] = append(snippets[i], text)
+			}
+		}
+		if citation := buildCitation(support, results); citation != nil {
+			resp.Citations = append(resp.Citations, citation)
+		}
+	}
+
+	_, contents := extractWebSearchResultsInternal(extractGroundingMetadata(geminiResp))
+	for i, result := range results {
+		if len(snippets[i]) == 0 && len(contents) == len(results) && contents[i] != "" {
+			snippets[i] = []string{contents[i]}
+		}
+		title, _ := result["title"].(string)
+		url, _ := result["url"].(string)
+		pageAge, _ := result["page_age"].(string)
+		resp.Results[i] = SearchResult{Title: title, URL: url, Snippet: strings.Join(snippets[i], " "), PageAge: pageAge}
+	}
+	return resp
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// defaultSearchTestModel is the Claude model named in converted test responses
+const defaultSearchTestModel = "claude-sonnet-4-5"
+
+// handleAdminSearchTest serves POST /admin/search-test {"query": "...", "model": "..."}.
+// It runs the full web search pipeline and returns the raw Gemini response next to the
+// converted Claude response, with the time spent in each phase. A complete Claude
+// messages payload can be passed as "payload" instead of a query.
+func (p *Proxy) handleAdminSearchTest(w http.ResponseWriter, r *http.Request) {
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	model := gjson.GetBytes(body, "model").String()
+	payload := []byte(gjson.GetBytes(body, "payload").Raw)
+	if len(payload) == 0 {
+		query := strings.TrimSpace(gjson.GetBytes(body, "query").String())
+		if query == "" {
+			writeError(w, http.StatusBadRequest, errTypeInvalidRequest, "query or payload is required")
+			return
+		}
+		payload, _ = json.Marshal(map[string]interface{}{
+			"messages": []map[string]string{{"role": "user", "content": query}},
+		})
+	} else if model == "" {
+		model = GetModel(payload)
+	}
+	if model == "" {
+		model = defaultSearchTestModel
+	}
+
+	ctx := withPhaseTimings(r.Context())
+	start := time.Now()
+	result := map[string]interface{}{
+		"key":   p.backend.KeyID(),
+		"model": p.cfg.WebSearchModel,
+	}
+
+	geminiResp, err := p.executeSearch(ctx, payload)
+	if err != nil {
+		result["error"] = err.Error()
+	} else {
+		stopConversion := trackConversion(ctx)
+		claudeResp := ConvertToClaudeNonStream(ctx, model, geminiResp, p.urlResolver)
+		stopConversion()
+		result["gemini_response"] = json.RawMessage(geminiResp)
+		result["claude_response"] = json.RawMessage(claudeResp)
+	}
+
+	timings := map[string]int64{"total": time.Since(start).Milliseconds()}
+	t := phaseTimingsFrom(ctx)
+	for _, phase := range latencyPhases {
+		timings[phase] = t.get(phase).Milliseconds()
+	}
+	result["timings_ms"] = timings
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	resp, _ := json.Marshal(result)
+	p.writeJSON(w, r, status, resp)
+}
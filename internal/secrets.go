@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Secret references resolved when the config is loaded
+const (
+	secretRefVault = "vault://" // vault://<path>#<field>, read from VAULT_ADDR with VAULT_TOKEN
+	secretRefGCPSM = "gcpsm://" // gcpsm://projects/<project>/secrets/<secret>[/versions/<version>]
+	defaultVault   = "https://127.0.0.1:8200"
+	secretTimeout  = 30 * time.Second
+	secretManager  = "https://secretmanager.googleapis.com/v1/"
+	vaultTokenFile = ".vault-token"
+)
+
+// isSecretRef reports whether a setting refers to a secret manager entry
+func isSecretRef(v string) bool {
+	return strings.HasPrefix(v, secretRefVault) || strings.HasPrefix(v, secretRefGCPSM)
+}
+
+// secretResolver fetches secrets referenced by URI from Vault or GCP Secret Manager
+type secretResolver struct {
+	client          *http.Client
+	transport       http.RoundTripper
+	credentialsFile string
+	credentialsJSON string
+	tokens          *adcTokenSource // created on the first gcpsm:// reference
+}
+
+// resolveSecretRefs replaces vault:// and gcpsm:// references in the secret settings with
+// the secrets they point to, so no secret has to be stored on disk
+func resolveSecretRefs(cfg *Config) error {
+	refs := []*string{&cfg.AdminToken, &cfg.AlertWebhookURL, &cfg.SerperAPIKey, &cfg.ExaAPIKey, &cfg.GoogleCSEAPIKey}
+	for i := range cfg.GeminiAPIKeys {
+		refs = append(refs, &cfg.GeminiAPIKeys[i])
+	}
+	for i := range cfg.ProxyAPIKeys {
+		refs = append(refs, &cfg.ProxyAPIKeys[i])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	var sr *secretResolver
+	for _, ref := range refs {
+		if !isSecretRef(*ref) {
+			continue
+		}
+		if sr == nil {
+			transport, err := NewOutboundTransport(cfg)
+			if err != nil {
+				return err
+			}
+			sr = &secretResolver{
+				client:          &http.Client{Transport: transport},
+				transport:       transport,
+				credentialsFile: cfg.GeminiCredentialsFile,
+				credentialsJSON: cfg.GeminiCredentialsJSON,
+			}
+		}
+		secret, err := sr.resolve(ctx, *ref)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secret %s: %w", *ref, err)
+		}
+		*ref = secret
+	}
+
+	if len(cfg.GeminiAPIKeys) > 0 {
+		cfg.GeminiAPIKey = cfg.GeminiAPIKeys[0]
+	}
+	return nil
+}
+
+// resolve fetches the secret a reference points to
+func (sr *secretResolver) resolve(ctx context.Context, ref string) (string, error) {
+	var secret string
+	var err error
+	if path, ok := strings.CutPrefix(ref, secretRefVault); ok {
+		secret, err = sr.resolveVault(ctx, path)
+	} else {
+		secret, err = sr.resolveGCPSM(ctx, strings.TrimPrefix(ref, secretRefGCPSM))
+	}
+	if err != nil {
+		return "", err
+	}
+	if secret = strings.TrimSpace(secret); secret == "" {
+		return "", fmt.Errorf("secret is empty")
+	}
+	return secret, nil
+}
+
+// resolveVault reads a field of a Vault KV secret (version 1 or 2)
+func (sr *secretResolver) resolveVault(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("vault reference needs a #field")
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = defaultVault
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, vaultTokenFile)); err == nil {
+				token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	body, err := sr.fetch(req)
+	if err != nil {
+		return "", err
+	}
+
+	// KV version 2 nests the secret in data.data, next to data.metadata
+	data := gjson.GetBytes(body, "data")
+	if data.Get("data").IsObject() && data.Get("metadata").IsObject() {
+		data = data.Get("data")
+	}
+	value := data.Get(gjson.Escape(field))
+	if !value.Exists() {
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	return value.String(), nil
+}
+
+// resolveGCPSM accesses a GCP Secret Manager secret version, the latest when not given,
+// with Application Default Credentials
+func (sr *secretResolver) resolveGCPSM(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	if sr.tokens == nil {
+		tokens, err := newADCTokenSource(sr.credentialsFile, sr.credentialsJSON, sr.transport)
+		if err != nil {
+			return "", err
+		}
+		sr.tokens = tokens
+	}
+	token, err := sr.tokens.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManager+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	sr.tokens.applyHeaders(req.Header)
+	body, err := sr.fetch(req)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(gjson.GetBytes(body, "payload.data").String())
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// fetch performs a secret request and returns the response body
+func (sr *secretResolver) fetch(req *http.Request) ([]byte, error) {
+	resp, err := sr.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const selfTestTimeout = 30 * time.Second
+
+// selfTestPayload is a minimal search request used to verify credentials and connectivity
+var selfTestPayload = []byte(`{"messages":[{"role":"user","content":"What is today's date? Answer in one short sentence."}]}`)
+
+// SelfTest performs a tiny googleSearch request and reports whether the Gemini
+// credentials and network path work, with a hint on what to check when they don't
+func (p *Proxy) SelfTest(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	geminiResp, err := p.backend.ExecuteSearch(ctx, selfTestPayload)
+	if err != nil {
+		hint := "check gemini_api_base_url, outbound_proxy and network connectivity"
+		var statusErr *geminiStatusError
+		var cooldownErr *geminiCooldownError
+		switch {
+		case errors.As(err, &cooldownErr):
+			hint = "every key is cooling down after a quota or auth error"
+		case errors.As(err, new(*QuotaError)):
+			hint = "the API key is out of quota"
+		case errors.As(err, new(*AuthError)) && p.cfg.GeminiAuth != GeminiAuthAPIKey:
+			hint = "check the Application Default Credentials and their scopes"
+		case errors.As(err, new(*AuthError)):
+			hint = "check gemini_api_key"
+		case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+			hint = "check web_search_model and gemini_api_base_url"
+		}
+		slog.Error("Self-test search failed", "key", p.backend.KeyID(), "model", p.cfg.WebSearchModel,
+			"hint", hint, "error", err)
+		return fmt.Errorf("self-test search failed (%s): %w", hint, err)
+	}
+
+	slog.Info("Self-test search passed", "key", p.backend.KeyID(), "model", p.cfg.WebSearchModel,
+		"results", len(extractGroundingMetadata(geminiResp).Get("groundingChunks").Array()),
+		"duration", time.Since(start))
+	return nil
+}
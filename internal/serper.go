@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// SearchBackendSerper searches Google through the Serper.dev SERP API
+const SearchBackendSerper = "serper"
+
+const serperSearchPath = "/search"
+
+func init() {
+	RegisterSearchBackend(SearchBackendSerper, newSerperBackend)
+}
+
+// serperBackend runs web searches with Serper.dev, returning Google's organic results
+// with the answer box and knowledge graph
+type serperBackend struct {
+	searchURL  string
+	apiKey     string
+	maxResults int
+	httpClient *http.Client
+}
+
+// newSerperBackend creates the Serper backend from serper_api_key and serper_base_url
+func newSerperBackend(cfg *Config, transport http.RoundTripper) (SearchBackend, error) {
+	if cfg.SerperAPIKey == "" {
+		return nil, fmt.Errorf("serper_api_key is required with search_backend %q", SearchBackendSerper)
+	}
+	return &serperBackend{
+		searchURL:  strings.TrimSuffix(cfg.SerperBaseURL, "/") + serperSearchPath,
+		apiKey:     cfg.SerperAPIKey,
+		maxResults: cfg.SearchMaxResults,
+		httpClient: &http.Client{Timeout: 60 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Name returns the name of the Serper backend
+func (sb *serperBackend) Name() string {
+	return SearchBackendSerper
+}
+
+// KeyID returns a short, non-reversible identifier of the Serper API key
+func (sb *serperBackend) KeyID() string {
+	return apiKeyID(sb.apiKey)
+}
+
+// ExecuteSearch searches Google with Serper for the last user message
+func (sb *serperBackend) ExecuteSearch(ctx context.Context, claudePayload []byte) ([]byte, error) {
+	query, err := searchQuery(ctx, claudePayload)
+	if err != nil {
+		return nil, err
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"q": query, "num": sb.maxResults})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sb.searchURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", sb.apiKey)
+	body, err := doEngineRequest(ctx, sb.httpClient, SearchBackendSerper, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseSerperResults(query, body, sb.maxResults).geminiResponse(), nil
+}
+
+// parseSerperResults collects the answer box as the answer, and the knowledge graph
+// followed by the organic results as results
+func parseSerperResults(query string, body []byte, maxResults int) *SearchResults {
+	sr := &SearchResults{Query: query}
+
+	answerBox := gjson.GetBytes(body, "answerBox")
+	if sr.Answer = answerBox.Get("answer").String(); sr.Answer == "" {
+		sr.Answer = answerBox.Get("snippet").String()
+	}
+
+	kg := gjson.GetBytes(body, "knowledgeGraph")
+	link := kg.Get("descriptionLink").String()
+	if link == "" {
+		link = kg.Get("website").String()
+	}
+	if link != "" {
+		snippet := kg.Get("description").String()
+		kg.Get("attributes").ForEach(func(name, value gjson.Result) bool {
+			snippet += fmt.Sprintf(" %s: %s.", name.String(), value.String())
+			return true
+		})
+		sr.Results = append(sr.Results, SearchResult{
+			Title:   kg.Get("title").String(),
+			URL:     link,
+			Snippet: strings.TrimSpace(snippet),
+		})
+	}
+
+	for _, r := range gjson.GetBytes(body, "organic").Array() {
+		if len(sr.Results) >= maxResults {
+			break
+		}
+		sr.Results = append(sr.Results, SearchResult{
+			Title:   r.Get("title").String(),
+			URL:     r.Get("link").String(),
+			Snippet: r.Get("snippet").String(),
+		})
+	}
+	return sr
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+const shadowSearchTimeout = 2 * time.Minute
+
+// shadowWebSearch runs the Gemini pipeline for a request that is being forwarded upstream
+// unchanged, logging a summary of the would-be response and recording it to
+// shadow_log_dir when configured. It never affects the client response.
+func (p *Proxy) shadowWebSearch(model string, body []byte) {
+	if !p.acquireSearchSlot() {
+		p.metrics.Inc("shadow.skipped")
+		return
+	}
+	defer p.releaseSearchSlot()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shadowSearchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	geminiResp, err := p.executeSearch(ctx, body)
+	if err != nil {
+		p.metrics.Inc("shadow.errors")
+		slog.Warn("Shadow web search failed", "model", model, "duration", time.Since(start), "error", err)
+		return
+	}
+	response := ConvertToClaudeNonStream(ctx, model, geminiResp, p.urlResolver)
+	p.metrics.Inc("shadow.searches")
+
+	id := "shadow_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	slog.Info("Shadow web search completed", "id", id, "model", model,
+		"results", len(gjson.Get(response, `content.#(type=="web_search_tool_result").content`).Array()),
+		"citations", len(gjson.Get(response, `content.#.citations|@flatten`).Array()),
+		"bytes", len(response), "duration", time.Since(start))
+
+	if p.cfg.ShadowLogDir == "" {
+		return
+	}
+	record := `{"id":"` + id + `","time":"` + start.UTC().Format(time.RFC3339) + `","request":` + string(body) + `,"response":` + response + "}\n"
+	if err := os.WriteFile(filepath.Join(p.cfg.ShadowLogDir, id+".json"), []byte(record), 0o600); err != nil {
+		slog.Error("Failed to record shadow web search", "id", id, "error", err)
+	}
+}
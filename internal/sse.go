@@ -13,6 +13,30 @@ import (
 // ConvertToClaudeSSEStream converts Gemini response to Claude SSE stream events
 // Now includes URL resolution and citations support
 func ConvertToClaudeSSEStream(ctx context.Context, model string, geminiResp []byte, resolver *URLResolver) []string {
+	inputTokens := getUsageField(geminiResp, "promptTokenCount")
+
+	events := []string{MessageStartEvent(NewMessageID(), model, inputTokens)}
+	return append(events, ConvertToClaudeSSEEvents(ctx, geminiResp, resolver)...)
+}
+
+// NewMessageID generates a Claude-style message ID
+func NewMessageID() string {
+	return fmt.Sprintf("msg_%s", uuid.New().String()[:24])
+}
+
+// MessageStartEvent builds the message_start SSE event that opens a Claude stream
+func MessageStartEvent(msgID, model string, inputTokens int64) string {
+	messageStart := fmt.Sprintf(
+		`{"type":"message_start","message":{"id":"","type":"message","role":"assistant","content":[],"model":"","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":%d,"output_tokens":0}}}`,
+		inputTokens)
+	messageStart, _ = sjson.Set(messageStart, "message.id", msgID)
+	messageStart, _ = sjson.Set(messageStart, "message.model", model)
+	return "event: message_start\ndata: " + messageStart + "\n\n"
+}
+
+// ConvertToClaudeSSEEvents converts Gemini response to the Claude SSE events that
+// follow message_start, so callers can emit message_start before the search completes
+func ConvertToClaudeSSEEvents(ctx context.Context, geminiResp []byte, resolver *URLResolver) []string {
 	var events []string
 
 	// Extract data from Gemini response
@@ -21,7 +45,6 @@ func ConvertToClaudeSSEStream(ctx context.Context, model string, geminiResp []by
 	inputTokens := getUsageField(geminiResp, "promptTokenCount")
 	outputTokens := getUsageField(geminiResp, "candidatesTokenCount")
 
-	msgID := fmt.Sprintf("msg_%s", uuid.New().String()[:24])
 	toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano())
 
 	// Build search query from webSearchQueries
@@ -30,12 +53,6 @@ func ConvertToClaudeSSEStream(ctx context.Context, model string, geminiResp []by
 		searchQuery = queries.Array()[0].String()
 	}
 
-	// 1. message_start
-	messageStart := fmt.Sprintf(
-		`{"type":"message_start","message":{"id":"%s","type":"message","role":"assistant","content":[],"model":"%s","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":%d,"output_tokens":0}}}`,
-		msgID, model, inputTokens)
-	events = append(events, "event: message_start\ndata: "+messageStart+"\n\n")
-
 	contentIndex := 0
 
 	// 2. server_tool_use block (index 0)
@@ -117,15 +134,37 @@ func ConvertToClaudeSSEStream(ctx context.Context, model string, geminiResp []by
 		}
 
 		events = append(events, fmt.Sprintf("event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":%d}\n\n", contentIndex))
+		contentIndex++
+	}
+
+	// 6. tool_use blocks for client tools Gemini called (hybrid tool mode)
+	stopReason := "end_turn"
+	for _, call := range extractFunctionCalls(geminiResp) {
+		toolUseStart := fmt.Sprintf(`{"type":"content_block_start","index":%d,"content_block":{"type":"tool_use","id":"","name":"","input":{}}}`, contentIndex)
+		toolUseStart, _ = sjson.Set(toolUseStart, "content_block.id", toolUseIDForCall(call))
+		toolUseStart, _ = sjson.Set(toolUseStart, "content_block.name", call.Get("name").String())
+		events = append(events, "event: content_block_start\ndata: "+toolUseStart+"\n\n")
+
+		argsJSON := "{}"
+		if args := call.Get("args"); args.IsObject() {
+			argsJSON = args.Raw
+		}
+		argsDelta := fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":""}}`, contentIndex)
+		argsDelta, _ = sjson.Set(argsDelta, "delta.partial_json", argsJSON)
+		events = append(events, "event: content_block_delta\ndata: "+argsDelta+"\n\n")
+
+		events = append(events, fmt.Sprintf("event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":%d}\n\n", contentIndex))
+		contentIndex++
+		stopReason = "tool_use"
 	}
 
-	// 6. message_delta with stop_reason and usage
+	// 7. message_delta with stop_reason and usage
 	messageDelta := fmt.Sprintf(
-		`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"input_tokens":%d,"output_tokens":%d,"server_tool_use":{"web_search_requests":1}}}`,
-		inputTokens, outputTokens)
+		`{"type":"message_delta","delta":{"stop_reason":"%s","stop_sequence":null},"usage":{"input_tokens":%d,"output_tokens":%d,"server_tool_use":{"web_search_requests":1}}}`,
+		stopReason, inputTokens, outputTokens)
 	events = append(events, "event: message_delta\ndata: "+messageDelta+"\n\n")
 
-	// 7. message_stop
+	// 8. message_stop
 	events = append(events, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
 
 	return events
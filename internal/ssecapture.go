@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sseCaptureWriter tees an SSE response into a capture file, byte for byte, while
+// passing it through to the client. Non-SSE responses are not captured.
+type sseCaptureWriter struct {
+	http.ResponseWriter
+	path string
+	file *os.File
+}
+
+// captureSSE wraps w so the SSE stream sent for the request is recorded to
+// sse_capture_dir; the returned function closes the capture
+func (p *Proxy) captureSSE(w http.ResponseWriter, requestID string) (http.ResponseWriter, func()) {
+	if p.cfg.SSECaptureDir == "" {
+		return w, func() {}
+	}
+	name := time.Now().UTC().Format("20060102T150405") + "_" + requestID + ".sse"
+	cw := &sseCaptureWriter{ResponseWriter: w, path: filepath.Join(p.cfg.SSECaptureDir, name)}
+	return cw, cw.close
+}
+
+func (cw *sseCaptureWriter) Write(b []byte) (int, error) {
+	if cw.file == nil && strings.HasPrefix(cw.Header().Get("Content-Type"), "text/event-stream") {
+		f, err := os.OpenFile(cw.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			slog.Error("Failed to create SSE capture", "path", cw.path, "error", err)
+			// Don't retry on every event
+			cw.path = ""
+		} else {
+			cw.file = f
+		}
+	}
+	if cw.file != nil {
+		cw.file.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (cw *sseCaptureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (cw *sseCaptureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *sseCaptureWriter) close() {
+	if cw.file != nil {
+		cw.file.Close()
+		slog.Info("SSE stream captured", "path", cw.path)
+	}
+}
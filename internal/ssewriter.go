@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const pingEvent = "event: ping\ndata: {\"type\":\"ping\"}\n\n"
+
+// sseWriter serializes SSE frames written to a client connection so that
+// keep-alive pings and converted events never interleave
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter writes the SSE response headers and returns a writer for the stream
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+// Send writes a single pre-formatted SSE event and flushes it to the client
+func (s *sseWriter) Send(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.w.Write([]byte(event))
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// StartPing emits a ping event right away, as the Messages API does after message_start,
+// and then at the given interval until the returned stop function is called. A
+// non-positive interval disables pings.
+func (s *sseWriter) StartPing(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	s.Send(pingEvent)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.Send(pingEvent)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdMaxPacket keeps datagrams below a typical Ethernet MTU
+const statsdMaxPacket = 1400
+
+// StatsD periodically exports the proxy counters to a StatsD or DogStatsD agent over
+// UDP and sends web_search phase timings as they happen. A nil StatsD sends nothing.
+type StatsD struct {
+	conn    net.Conn
+	prefix  string
+	tags    string // DogStatsD "|#a:b,c:d" suffix, empty without tags
+	metrics *Metrics
+	last    map[string]int64 // counter values at the previous flush
+}
+
+// NewStatsD connects to the agent at addr and starts flushing counter deltas every interval
+func NewStatsD(addr, prefix string, tags []string, interval time.Duration, metrics *Metrics) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsD{conn: conn, prefix: prefix, metrics: metrics, last: make(map[string]int64)}
+	if len(tags) > 0 {
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+// run sends counter deltas on every tick
+func (s *StatsD) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.flush()
+	}
+}
+
+// flush sends the change of every counter since the previous flush
+func (s *StatsD) flush() {
+	var lines []string
+	for name, value := range s.metrics.Snapshot() {
+		if delta := value - s.last[name]; delta != 0 {
+			lines = append(lines, s.line(name, strconv.FormatInt(delta, 10), "c"))
+		}
+		s.last[name] = value
+	}
+	s.send(lines)
+}
+
+// Timing sends a single timer sample in milliseconds
+func (s *StatsD) Timing(name string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.send([]string{s.line(name, strconv.FormatInt(d.Milliseconds(), 10), "ms")})
+}
+
+// line formats one metric in the StatsD wire format
+func (s *StatsD) line(name, value, kind string) string {
+	return s.prefix + name + ":" + value + "|" + kind + s.tags
+}
+
+// send writes the lines in as few datagrams as possible. Delivery is best effort.
+func (s *StatsD) send(lines []string) {
+	var packet strings.Builder
+	write := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write([]byte(packet.String())); err != nil {
+			slog.Debug("Failed to send StatsD metrics", "error", err)
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			write()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	write()
+}
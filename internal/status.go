@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Version is the proxy version, set at build time with
+// -ldflags "-X github.com/cliproxyapi/cpa_websearch_proxy/internal.Version=v1.2.3"
+var Version = "dev"
+
+// upstreamStatus reports the health of a single upstream
+type upstreamStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+}
+
+// handleStatus serves GET /status: version, uptime, upstream health, cache sizes
+// and cumulative counters
+func (p *Proxy) handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp, _ := json.Marshal(p.statusReport())
+	p.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// statusReport collects the data reported by /status
+func (p *Proxy) statusReport() map[string]interface{} {
+	var upstreams []upstreamStatus
+	if p.upstreamProxy != nil {
+		upstreams = append(upstreams, p.upstreamProxy.Status()...)
+	}
+	for _, rt := range p.modelRoutes {
+		upstreams = append(upstreams, rt.upstream.Status()...)
+	}
+
+	batches := 0
+	p.batches.batches.Range(func(_, _ interface{}) bool {
+		batches++
+		return true
+	})
+
+	counters := p.metrics.Snapshot()
+
+	return map[string]interface{}{
+		"version":         Version,
+		"started_at":      p.startedAt.UTC().Format(time.RFC3339),
+		"uptime_seconds":  int64(time.Since(p.startedAt).Seconds()),
+		"in_flight":       p.InFlight(),
+		"active_searches": p.ActiveSearches(),
+		"search_backend":  p.backend.Name(),
+		"search_backends": p.backendStatus(),
+		"gemini": map[string]interface{}{
+			"model": p.cfg.WebSearchModel,
+			"key":   p.geminiClient.KeyID(),
+			"keys":  p.adminAuthEntries(),
+		},
+		"upstreams": upstreams,
+		"caches": map[string]int{
+			"resolved_urls": p.urlResolver.Len(),
+			"batches":       batches,
+		},
+		"daily_usage":    p.usage.Today(),
+		"key_usage":      keyUsage(counters),
+		"latency_avg_ms": latencyAverages(counters),
+		"counters":       counters,
+	}
+}
+
+// backendStatus lists the backends searches go to, in the order they are tried
+func (p *Proxy) backendStatus() []backendStatus {
+	if chain, ok := p.backend.(*backendChain); ok {
+		return chain.status()
+	}
+	return []backendStatus{{Name: p.backend.Name(), Key: p.backend.KeyID()}}
+}
+
+// keyUsage groups the per-key "gemini.keys.<key>.<counter>" counters by key
+func keyUsage(counters map[string]int64) map[string]map[string]int64 {
+	usage := make(map[string]map[string]int64)
+	for name, value := range counters {
+		rest, ok := strings.CutPrefix(name, "gemini.keys.")
+		if !ok {
+			continue
+		}
+		key, counter, ok := strings.Cut(rest, ".")
+		if !ok {
+			continue
+		}
+		if usage[key] == nil {
+			usage[key] = make(map[string]int64)
+		}
+		usage[key][counter] = value
+	}
+	return usage
+}
+
+// latencyAverages returns the mean time per intercepted web_search spent in each phase
+func latencyAverages(counters map[string]int64) map[string]int64 {
+	requests := counters["latency.requests"]
+	if requests == 0 {
+		return nil
+	}
+	avg := map[string]int64{"total": counters["latency.total.ms_total"] / requests}
+	for _, phase := range latencyPhases {
+		avg[phase] = counters["latency."+phase+".ms_total"] / requests
+	}
+	return avg
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/sjson"
+)
+
+// Web search tool result error codes
+const (
+	toolErrMaxUsesExceeded = "max_uses_exceeded"
+	toolErrTooManyRequests = "too_many_requests"
+)
+
+// buildToolErrorBlocks builds the server_tool_use block and a web_search_tool_result
+// carrying the given error code instead of search results
+func buildToolErrorBlocks(query, errorCode string) []map[string]interface{} {
+	toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano())
+	return []map[string]interface{}{
+		{
+			"type":  "server_tool_use",
+			"id":    toolUseID,
+			"name":  "web_search",
+			"input": map[string]interface{}{"query": query},
+		},
+		{
+			"type":        "web_search_tool_result",
+			"tool_use_id": toolUseID,
+			"content": map[string]interface{}{
+				"type":       "web_search_tool_result_error",
+				"error_code": errorCode,
+			},
+		},
+	}
+}
+
+// ConvertToolErrorNonStream builds a Claude non-streaming response whose web_search
+// tool result is an error, for cases where no search is performed
+func ConvertToolErrorNonStream(model, query, errorCode string) string {
+	response := map[string]interface{}{
+		"id":            NewMessageID(),
+		"type":          "message",
+		"role":          "assistant",
+		"content":       buildToolErrorBlocks(query, errorCode),
+		"model":         model,
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":  0,
+			"output_tokens": 0,
+			"server_tool_use": map[string]interface{}{
+				"web_search_requests": 0,
+			},
+		},
+	}
+
+	respJSON, _ := json.Marshal(response)
+	return string(respJSON)
+}
+
+// ConvertToolErrorSSEEvents builds the Claude SSE events following message_start for a
+// response whose web_search tool result is an error
+func ConvertToolErrorSSEEvents(query, errorCode string) []string {
+	var events []string
+
+	for i, block := range buildToolErrorBlocks(query, errorCode) {
+		start := fmt.Sprintf(`{"type":"content_block_start","index":%d,"content_block":{}}`, i)
+		if block["type"] == "server_tool_use" {
+			// Tool input is streamed as a delta, so the start block carries an empty input
+			input := block["input"]
+			block["input"] = map[string]interface{}{}
+			start, _ = sjson.Set(start, "content_block", block)
+			events = append(events, "event: content_block_start\ndata: "+start+"\n\n")
+
+			inputJSON, _ := json.Marshal(input)
+			delta := fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":""}}`, i)
+			delta, _ = sjson.Set(delta, "delta.partial_json", string(inputJSON))
+			events = append(events, "event: content_block_delta\ndata: "+delta+"\n\n")
+		} else {
+			start, _ = sjson.Set(start, "content_block", block)
+			events = append(events, "event: content_block_start\ndata: "+start+"\n\n")
+		}
+		events = append(events, fmt.Sprintf("event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":%d}\n\n", i))
+	}
+
+	events = append(events, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":0,\"server_tool_use\":{\"web_search_requests\":0}}}\n\n")
+	events = append(events, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	return events
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// newTunedTransport clones the default transport and applies the connection pool,
+// keep-alive and HTTP/2 settings from cfg
+func newTunedTransport(cfg *Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
+	}).DialContext
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	if cfg.MaxIdleConnsPerHost > transport.MaxIdleConns {
+		transport.MaxIdleConns = cfg.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = time.Duration(cfg.IdleConnTimeout) * time.Second
+	if cfg.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// NewUpstreamTransport builds the transport used by the reverse proxy to reach upstreams
+func NewUpstreamTransport(cfg *Config) *http.Transport {
+	return newTunedTransport(cfg)
+}
+
+// NewOutboundTransport builds the transport used for requests to Google (Gemini API and
+// redirect resolution). It honors outbound_proxy (http, https or socks5 URL), falling back
+// to HTTPS_PROXY/HTTP_PROXY/NO_PROXY, and trusts ca_bundle in addition to the system roots.
+func NewOutboundTransport(cfg *Config) (*http.Transport, error) {
+	transport := newTunedTransport(cfg)
+
+	if cfg.OutboundProxy != "" {
+		proxyURL, err := url.Parse(cfg.OutboundProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound_proxy %q: %w", cfg.OutboundProxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.CABundle != "" || cfg.TLSInsecureSkipVerify {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CABundle != "" {
+			pem, err := os.ReadFile(cfg.CABundle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ca_bundle: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in ca_bundle %s", cfg.CABundle)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		tlsConfig.InsecureSkipVerify = cfg.TLSInsecureSkipVerify
+		if cfg.DisableHTTP2 {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport, nil
+}
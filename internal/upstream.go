@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// forwardedClientHeaders are the client headers passed along when the proxy itself
+// issues requests to the upstream on the client's behalf
+var forwardedClientHeaders = []string{"x-api-key", "Authorization", "anthropic-version", "anthropic-beta"}
+
+// doUpstreamRequest issues a request to upstream on behalf of the client request r,
+// forwarding its credentials, and returns the response status and body. It goes through
+// the pool's transport, so upstream TLS, proxy and retry settings apply as they do to
+// proxied requests.
+func (p *Proxy) doUpstreamRequest(ctx context.Context, upstream *UpstreamPool, r *http.Request, method, path string, body []byte) (int, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, upstream.BaseURL()+path, reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, h := range forwardedClientHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	p.cfg.UpstreamHeaders.Apply(req.Header)
+
+	resp, err := upstream.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readResponseBody(resp)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+	// upstreamFailCooldown is how long a failed upstream is skipped when no health checks
+	// run to bring it back
+	upstreamFailCooldown = DefaultHealthInterval * time.Second
+)
+
+// upstreamTarget is a single upstream with its own reverse proxy and health state
+type upstreamTarget struct {
+	url      *url.URL
+	proxy    *httputil.ReverseProxy
+	healthy  atomic.Bool
+	failedAt atomic.Int64 // unix nanoseconds of the last failure
+}
+
+// UpstreamPool forwards requests to the first healthy upstream in priority order,
+// failing over to the next one when an upstream errors or fails its health check.
+// Idempotent requests that fail are retried on the next upstream.
+type UpstreamPool struct {
+	targets    []*upstreamTarget
+	healthPath string
+	checked    bool         // health checks run and bring failed upstreams back
+	client     *http.Client // for requests the proxy issues itself, e.g. batch and model list calls
+}
+
+// NewUpstreamPool creates a pool for the given upstream URLs using the upstream settings
+// from cfg. Health checks run in the background when more than one upstream is configured.
+func NewUpstreamPool(urls []string, cfg *Config, metrics *Metrics) (*UpstreamPool, error) {
+	base := NewUpstreamTransport(cfg)
+	var transport http.RoundTripper = base
+	if cfg.UpstreamRetries > 0 {
+		transport = &retryTransport{
+			base:    base,
+			retries: cfg.UpstreamRetries,
+			backoff: time.Duration(cfg.UpstreamRetryBackoff) * time.Millisecond,
+			metrics: metrics,
+		}
+	}
+	pool := &UpstreamPool{healthPath: cfg.UpstreamHealthPath, client: &http.Client{Transport: transport}}
+
+	for _, raw := range urls {
+		upstream, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream URL %q: %w", raw, err)
+		}
+
+		target := &upstreamTarget{url: upstream}
+		target.healthy.Store(true)
+
+		reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
+		// Flush every write so streamed tokens reach the client immediately, even
+		// when the upstream's streaming response isn't recognized as SSE
+		reverseProxy.FlushInterval = -1
+		reverseProxy.Transport = transport
+		originalDirector := reverseProxy.Director
+		reverseProxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.Host = upstream.Host
+			cfg.UpstreamHeaders.Apply(req.Header)
+		}
+		reverseProxy.ModifyResponse = func(resp *http.Response) error {
+			if resp.StatusCode < http.StatusInternalServerError && target.healthy.CompareAndSwap(false, true) {
+				slog.Info("Upstream is responding again", "upstream", upstream.Host)
+			}
+			return nil
+		}
+		reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			class := classifyUpstreamError(err)
+			metrics.Inc("upstream.errors." + class)
+			slog.Warn("Upstream error", "upstream", upstream.Host, "class", class, "method", r.Method, "path", r.URL.Path, "error", err)
+
+			if class == upstreamErrCanceled {
+				// The client went away; nobody is left to read a response
+				return
+			}
+			if len(pool.targets) > 1 {
+				target.failedAt.Store(time.Now().UnixNano())
+				if target.healthy.CompareAndSwap(true, false) {
+					slog.Warn("Upstream failed, marking unhealthy", "upstream", upstream.Host)
+				}
+			}
+
+			// Requests that can safely be sent twice move on to the next upstream
+			if next := pool.after(target); next != nil && replayable(r) {
+				metrics.Inc("upstream.failovers")
+				slog.Info("Retrying on the next upstream", "upstream", next.url.Host, "method", r.Method, "path", r.URL.Path)
+				next.proxy.ServeHTTP(w, r)
+				return
+			}
+
+			status := http.StatusBadGateway
+			if class == upstreamErrTimeout {
+				status = http.StatusGatewayTimeout
+			}
+			writeError(w, status, errTypeAPI, fmt.Sprintf("Upstream %s unavailable (%s)", upstream.Host, class))
+		}
+		target.proxy = reverseProxy
+
+		pool.targets = append(pool.targets, target)
+	}
+
+	if len(pool.targets) > 1 && cfg.UpstreamHealthInterval > 0 {
+		pool.checked = true
+		go pool.runHealthChecks(time.Duration(cfg.UpstreamHealthInterval)*time.Second, base)
+	}
+
+	return pool, nil
+}
+
+// current returns the first available upstream, or the primary one if none are
+func (up *UpstreamPool) current() *upstreamTarget {
+	for _, target := range up.targets {
+		if up.available(target) {
+			return target
+		}
+	}
+	return up.targets[0]
+}
+
+// after returns the first available upstream following target in priority order, or nil
+func (up *UpstreamPool) after(target *upstreamTarget) *upstreamTarget {
+	for i, t := range up.targets {
+		if t != target {
+			continue
+		}
+		for _, next := range up.targets[i+1:] {
+			if up.available(next) {
+				return next
+			}
+		}
+		break
+	}
+	return nil
+}
+
+// available reports whether target should receive traffic: it is healthy or, without
+// health checks to bring it back, its last failure is upstreamFailCooldown old
+func (up *UpstreamPool) available(target *upstreamTarget) bool {
+	if target.healthy.Load() {
+		return true
+	}
+	return !up.checked && time.Since(time.Unix(0, target.failedAt.Load())) >= upstreamFailCooldown
+}
+
+// replayable reports whether a failed request can be sent again: its method is
+// idempotent and it has no body that was consumed by the first attempt
+func replayable(r *http.Request) bool {
+	return idempotentMethod(r.Method) && (r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0)
+}
+
+// BaseURL returns the base URL of the upstream currently receiving traffic
+func (up *UpstreamPool) BaseURL() string {
+	return strings.TrimSuffix(up.current().url.String(), "/")
+}
+
+// Status reports the health of every upstream in the pool
+func (up *UpstreamPool) Status() []upstreamStatus {
+	status := make([]upstreamStatus, len(up.targets))
+	for i, target := range up.targets {
+		status[i] = upstreamStatus{URL: target.url.String(), Healthy: target.healthy.Load()}
+	}
+	return status
+}
+
+// ServeHTTP implements http.Handler
+func (up *UpstreamPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	up.current().proxy.ServeHTTP(w, r)
+}
+
+// Upstream error classes reported in error responses and metrics
+const (
+	upstreamErrCanceled = "canceled"
+	upstreamErrTimeout  = "timeout"
+	upstreamErrRefused  = "connection_refused"
+	upstreamErrReset    = "connection_reset"
+	upstreamErrDNS      = "dns_error"
+	upstreamErrTLS      = "tls_error"
+	upstreamErrOther    = "upstream_error"
+)
+
+// classifyUpstreamError maps a reverse proxy error to a coarse error class
+func classifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return upstreamErrCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return upstreamErrTimeout
+	case errors.As(err, &dnsErr):
+		return upstreamErrDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return upstreamErrRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return upstreamErrReset
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return upstreamErrTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return upstreamErrTimeout
+	default:
+		return upstreamErrOther
+	}
+}
+
+// runHealthChecks periodically probes every upstream and updates its health state. Probes
+// go through transport, without retries, so they take the same network path as traffic.
+func (up *UpstreamPool) runHealthChecks(interval time.Duration, transport http.RoundTripper) {
+	client := &http.Client{Timeout: healthCheckTimeout, Transport: transport}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, target := range up.targets {
+			healthy := up.check(client, target)
+			if was := target.healthy.Swap(healthy); was != healthy {
+				if healthy {
+					slog.Info("Upstream is healthy again", "upstream", target.url.Host)
+				} else {
+					slog.Warn("Upstream failed health check, marking unhealthy", "upstream", target.url.Host)
+				}
+			}
+		}
+	}
+}
+
+// check probes a single upstream; any response below 500 counts as healthy
+func (up *UpstreamPool) check(client *http.Client, target *upstreamTarget) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(target.url.String(), "/")+up.healthPath, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
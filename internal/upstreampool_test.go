@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUpstreamPoolFlushesStreamedFrames checks that every SSE frame of a streaming
+// upstream response reaches the client before the upstream writes the next one
+func TestUpstreamPoolFlushesStreamedFrames(t *testing.T) {
+	const frames = 3
+	received := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < frames; i++ {
+			fmt.Fprintf(w, "event: content_block_delta\ndata: {\"index\":%d}\n\n", i)
+			w.(http.Flusher).Flush()
+			// Hold the next frame back until the client has read this one
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Errorf("frame %d did not reach the client before the next one was due", i)
+				return
+			case <-r.Context().Done():
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	pool, err := NewUpstreamPool([]string{upstream.URL}, &Config{}, NewMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(pool)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < frames; i++ {
+		frame, err := readSSEFrame(reader)
+		if err != nil {
+			t.Fatalf("reading frame %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("data: {\"index\":%d}", i); !strings.Contains(frame, want) {
+			t.Fatalf("frame %d = %q, want it to contain %q", i, frame, want)
+		}
+		received <- struct{}{}
+	}
+}
+
+// readSSEFrame reads one SSE frame, up to and including its terminating blank line
+func readSSEFrame(r *bufio.Reader) (string, error) {
+	var frame strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return frame.String(), err
+		}
+		if line == "\n" {
+			return frame.String(), nil
+		}
+		frame.WriteString(line)
+	}
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"net/url"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// urlRetrievalSuccess is the urlRetrievalStatus of a page the urlContext tool read
+const urlRetrievalSuccess = "URL_RETRIEVAL_STATUS_SUCCESS"
+
+// mergeURLContextMetadata appends the pages the urlContext tool read to the grounding
+// chunks of a Gemini response, so they are returned as search results along with the
+// pages Google Search found. Pages already among the chunks and pages that failed to load
+// are left out.
+func mergeURLContextMetadata(geminiResp []byte) []byte {
+	prefix := "candidates.0"
+	if gjson.GetBytes(geminiResp, "response."+prefix).Exists() {
+		prefix = "response." + prefix
+	}
+	metadata := gjson.GetBytes(geminiResp, prefix+".urlContextMetadata.urlMetadata").Array()
+	if len(metadata) == 0 {
+		return geminiResp
+	}
+
+	chunksPath := prefix + ".groundingMetadata.groundingChunks"
+	present := make(map[string]bool)
+	for _, chunk := range gjson.GetBytes(geminiResp, chunksPath).Array() {
+		present[chunk.Get("web.uri").String()] = true
+	}
+	for _, m := range metadata {
+		uri := m.Get("retrievedUrl").String()
+		if uri == "" || present[uri] || m.Get("urlRetrievalStatus").String() != urlRetrievalSuccess {
+			continue
+		}
+		present[uri] = true
+		// Like Google Search chunks, which are titled with the site's domain
+		title := uri
+		if u, err := url.Parse(uri); err == nil && u.Hostname() != "" {
+			title = u.Hostname()
+		}
+		geminiResp, _ = sjson.SetBytes(geminiResp, chunksPath+".-1", map[string]interface{}{
+			"web": map[string]interface{}{"uri": uri, "title": title},
+		})
+	}
+	return geminiResp
+}
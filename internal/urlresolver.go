@@ -21,10 +21,11 @@ type URLResolver struct {
 }
 
 // NewURLResolver creates a new URL resolver instance
-func NewURLResolver() *URLResolver {
+func NewURLResolver(transport http.RoundTripper) *URLResolver {
 	return &URLResolver{
 		httpClient: &http.Client{
-			Timeout: resolveTimeout,
+			Timeout:   resolveTimeout,
+			Transport: transport,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				// Allow redirects to capture final URL
 				return nil
@@ -60,6 +61,27 @@ func (r *URLResolver) ResolveURL(ctx context.Context, url string) string {
 	return finalURL
 }
 
+// Len returns the number of cached URL resolutions
+func (r *URLResolver) Len() int {
+	n := 0
+	r.cache.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Flush empties the resolution cache and returns the number of entries removed
+func (r *URLResolver) Flush() int {
+	n := 0
+	r.cache.Range(func(k, _ interface{}) bool {
+		r.cache.Delete(k)
+		n++
+		return true
+	})
+	return n
+}
+
 // doResolve performs the actual HTTP request to resolve the URL
 func (r *URLResolver) doResolve(ctx context.Context, url string) string {
 	// Try HEAD request first (lighter)
@@ -105,6 +127,7 @@ func (r *URLResolver) ResolveURLs(ctx context.Context, urls []string) []string {
 	if len(urls) == 0 {
 		return urls
 	}
+	defer trackPhase(ctx, phaseURLResolution)()
 
 	result := make([]string, len(urls))
 	copy(result, urls)
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// geminiStatusError is returned when the Gemini API answers with a non-2xx status
+type geminiStatusError struct {
+	StatusCode int
+	BodyBytes  int
+	BodySHA256 string
+}
+
+func (e *geminiStatusError) Error() string {
+	return fmt.Sprintf("gemini returned status %d (response_bytes=%d, response_sha256=%s)",
+		e.StatusCode, e.BodyBytes, e.BodySHA256)
+}
+
+// recordKeyUsage updates the per-key counters (searches, successes, failures, auth and
+// quota errors, and tokens) and the key's daily request count for a request made with a key
+func (p *Proxy) recordKeyUsage(keyID string, geminiResp []byte, err error) {
+	prefix := "gemini.keys." + keyID + "."
+	var cooldownErr *geminiCooldownError
+	if errors.As(err, &cooldownErr) {
+		// Gemini wasn't contacted
+		p.metrics.Inc(prefix + "cooldown_skipped")
+		return
+	}
+	p.metrics.Inc(prefix + "searches")
+	if limit := p.keyDailyLimit(keyID); p.usage.RecordKeyRequest(keyID) == limit {
+		slog.Info("Gemini key reached its daily request limit, rotating until UTC midnight", "key", keyID, "limit", limit)
+	}
+
+	if err != nil {
+		p.metrics.Inc(prefix + "failures")
+		var authErr *AuthError
+		var quotaErr *QuotaError
+		var transientErr *TransientError
+		switch {
+		case errors.As(err, &authErr):
+			p.metrics.Inc(prefix + "unauthorized")
+			p.alerter.Fire(alertAuthFailed, fmt.Sprintf(
+				"Gemini rejected API key %s (%v); web search is failing", keyID, authErr.Err))
+		case errors.As(err, &quotaErr):
+			p.metrics.Inc(prefix + "rate_limited")
+			p.alerter.Fire(alertQuotaExhausted, fmt.Sprintf(
+				"Gemini API key %s hit its quota (%v); web search is failing", keyID, quotaErr.Err))
+		case errors.As(err, &transientErr):
+			p.metrics.Inc(prefix + "transient_errors")
+		}
+		return
+	}
+
+	p.metrics.Inc(prefix + "successes")
+	p.metrics.Add(prefix+"tokens.prompt", getUsageField(geminiResp, "promptTokenCount"))
+	p.metrics.Add(prefix+"tokens.candidates", getUsageField(geminiResp, "candidatesTokenCount"))
+}
+
+// keyDailyLimit returns the daily request limit of a key, 0 when unlimited
+func (p *Proxy) keyDailyLimit(keyID string) int64 {
+	if limit, ok := p.cfg.GeminiKeyDailyLimits[keyID]; ok {
+		return limit
+	}
+	return p.cfg.GeminiKeyDailyLimit
+}
+
+// keyQuotaReached reports whether a key has used up its daily request limit
+func (p *Proxy) keyQuotaReached(keyID string) bool {
+	limit := p.keyDailyLimit(keyID)
+	return limit > 0 && p.usage.KeyRequests(keyID) >= limit
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Credential validation outcomes
+const (
+	CredentialValid       = "valid"
+	CredentialRejected    = "rejected"
+	CredentialRevoked     = "revoked"
+	CredentialRateLimited = "rate_limited"
+	CredentialError       = "error"
+)
+
+// CredentialCheck is the outcome of validating one Gemini key or set of credentials
+type CredentialCheck struct {
+	Key    string
+	Status string
+	Reason string
+}
+
+// ValidateCredentials checks every configured Gemini key, or the Application Default
+// Credentials, by looking up the web search model with it, which also refreshes OAuth
+// tokens. With search set it performs a tiny web search with each key instead.
+func ValidateCredentials(ctx context.Context, cfg *Config, search bool) ([]CredentialCheck, error) {
+	transport, err := NewOutboundTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	gc, err := NewGeminiClient(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []CredentialCheck
+	for _, k := range gc.keys.all() {
+		checkCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		if search {
+			_, err = gc.executeWithRetries(checkCtx, selfTestPayload, k)
+		} else {
+			err = gc.checkKey(checkCtx, k)
+		}
+		cancel()
+		checks = append(checks, credentialCheck(k.id, err))
+	}
+	return checks, nil
+}
+
+// credentialCheck classifies the outcome of a validation request
+func credentialCheck(keyID string, err error) CredentialCheck {
+	check := CredentialCheck{Key: keyID, Status: CredentialValid}
+	if err == nil {
+		return check
+	}
+	check.Reason = err.Error()
+	var revokedErr *revokedCredentialsError
+	switch {
+	case errors.As(err, &revokedErr):
+		check.Status = CredentialRevoked
+		check.Reason += "; " + revokedErr.Hint()
+	case errors.As(err, new(*AuthError)):
+		check.Status = CredentialRejected
+	case errors.As(err, new(*QuotaError)):
+		check.Status = CredentialRateLimited
+	default:
+		check.Status = CredentialError
+	}
+	return check
+}
+
+// checkKey looks up the web search model with key k, without running a search
+func (gc *GeminiClient) checkKey(ctx context.Context, k *geminiKey) error {
+	reqURL := strings.TrimSuffix(gc.generateURL, ":generateContent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if err := gc.authorize(ctx, req, k); err != nil {
+		return err
+	}
+	gc.headers.Apply(req.Header)
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return classifyGeminiTransportError(ctx, fmt.Errorf("gemini request failed: %w", err))
+	}
+	defer resp.Body.Close()
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return fmt.Errorf("failed to read gemini response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Unlike search responses, the model lookup error carries nothing sensitive
+		if msg := gjson.GetBytes(body, "error.message").String(); msg != "" {
+			return fmt.Errorf("%w: %s", classifyGeminiStatus(resp, body), msg)
+		}
+		return classifyGeminiStatus(resp, body)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// web_fetch_tool_result error codes
+const (
+	fetchErrMaxUsesExceeded        = "max_uses_exceeded"
+	fetchErrURLTooLong             = "url_too_long"
+	fetchErrURLNotAllowed          = "url_not_allowed"
+	fetchErrURLNotAccessible       = "url_not_accessible"
+	fetchErrUnsupportedContentType = "unsupported_content_type"
+)
+
+const (
+	// webFetchMaxURLs caps the pages fetched for one request
+	webFetchMaxURLs = 3
+	// webFetchMaxURLLength is the longest URL web_fetch accepts
+	webFetchMaxURLLength = 250
+	// webFetchTimeout bounds fetching the pages of one request
+	webFetchTimeout = 30 * time.Second
+	// charsPerToken converts the tool's max_content_tokens into characters
+	charsPerToken = 4
+)
+
+// messageURL matches http(s) URLs in message text
+var messageURL = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// handleWebFetch serves a request declaring the web_fetch tool, which upstreams without
+// server tools reject: the pages the last user message links to are fetched, injected
+// into the conversation as web_fetch_tool_result blocks and the request is forwarded
+// upstream without the server tools, so the upstream model answers from the pages
+func (p *Proxy) handleWebFetch(w http.ResponseWriter, r *http.Request, body []byte, model string) {
+	upstream := p.upstreamFor(model)
+	if upstream == nil {
+		writeError(w, http.StatusBadGateway, errTypeAPI, "No upstream configured to answer the web_fetch request")
+		return
+	}
+
+	blocks := p.fetchBlocks(r.Context(), body)
+	augmented, err := StripServerTools(body)
+	if err == nil && len(blocks) > 0 {
+		augmented, err = InjectSearchResults(augmented, blocks)
+	}
+	if err != nil {
+		slog.Error("Failed to inject web_fetch results", "error", err)
+		writeError(w, http.StatusInternalServerError, errTypeAPI, "Failed to build upstream request")
+		return
+	}
+
+	slog.Debug("Forwarding conversation with injected web_fetch blocks upstream", "blocks", len(blocks))
+	setRequestBody(r, augmented)
+	w.Header().Del("request-id") // the upstream supplies its own
+	upstream.ServeHTTP(w, r)
+}
+
+// webFetchURLs returns the URLs the last user message links to that the conversation
+// hasn't fetched yet
+func webFetchURLs(payload []byte) []string {
+	fetched := make(map[string]bool)
+	for _, msg := range gjson.GetBytes(payload, "messages").Array() {
+		for _, block := range msg.Get("content").Array() {
+			if block.Get("type").String() == "web_fetch_tool_result" {
+				fetched[block.Get("content.url").String()] = true
+			}
+		}
+	}
+
+	var urls []string
+	for _, u := range messageURLs(ExtractUserQuery(payload)) {
+		if !fetched[u] {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// messageURLs returns the distinct http(s) URLs in message text, without the punctuation
+// that ends the sentence around them
+func messageURLs(text string) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, u := range messageURL.FindAllString(text, -1) {
+		u = strings.TrimRight(u, ".,;:!?)]}")
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// fetchBlocks fetches the pages the last user message links to, within the tool's
+// max_uses, and returns a server_tool_use and web_fetch_tool_result block for each
+func (p *Proxy) fetchBlocks(ctx context.Context, payload []byte) []map[string]interface{} {
+	urls := webFetchURLs(payload)
+	if len(urls) == 0 {
+		return nil
+	}
+	tool := webFetchTool(payload)
+	if maxUses := int(tool.Get("max_uses").Int()); maxUses > 0 {
+		remaining := maxUses - CountWebFetchResults(payload)
+		if remaining <= 0 {
+			slog.Info("web_fetch max_uses reached, returning max_uses_exceeded", "max_uses", maxUses)
+			return webFetchBlocks(tool, 0, urls[0], nil, fetchErrMaxUsesExceeded)
+		}
+		urls = urls[:min(len(urls), remaining)]
+	}
+	urls = urls[:min(len(urls), webFetchMaxURLs)]
+
+	maxChars := p.cfg.WebFetchMaxChars
+	if maxTokens := int(tool.Get("max_content_tokens").Int()); maxTokens > 0 {
+		maxChars = min(maxChars, maxTokens*charsPerToken)
+	}
+	filter := toolDomainFilter(tool)
+
+	ctx, cancel := context.WithTimeout(ctx, webFetchTimeout)
+	defer cancel()
+	pages := make([]*fetchedPage, len(urls))
+	codes := make([]string, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		if codes[i] = webFetchURLError(u, filter); codes[i] != "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page, err := fetchPage(ctx, p.fetchClient, u)
+			if err != nil {
+				slog.Warn("web_fetch failed", "url", u, "error", err)
+				switch {
+				case errors.As(err, new(*unsupportedContentError)):
+					codes[i] = fetchErrUnsupportedContentType
+				case errors.As(err, new(*blockedAddressError)):
+					codes[i] = fetchErrURLNotAllowed
+				default:
+					codes[i] = fetchErrURLNotAccessible
+				}
+				return
+			}
+			page.Text = trimText(page.Text, maxChars)
+			pages[i] = page
+		}()
+	}
+	wg.Wait()
+
+	var blocks []map[string]interface{}
+	for i, u := range urls {
+		p.metrics.Inc("web_fetch.requests")
+		if codes[i] != "" {
+			p.metrics.Inc("web_fetch.errors")
+		}
+		blocks = append(blocks, webFetchBlocks(tool, i, u, pages[i], codes[i])...)
+	}
+	return blocks
+}
+
+// webFetchURLError returns the error code for a URL web_fetch must not fetch, or ""
+func webFetchURLError(rawURL string, filter *DomainFilter) string {
+	if len(rawURL) > webFetchMaxURLLength {
+		return fetchErrURLTooLong
+	}
+	if u, err := url.Parse(rawURL); err != nil || u.Host == "" {
+		return fetchErrURLNotAccessible
+	}
+	if filter != nil && !filter.Allows(rawURL) {
+		return fetchErrURLNotAllowed
+	}
+	return ""
+}
+
+// webFetchBlocks builds the server_tool_use block for fetching rawURL and the
+// web_fetch_tool_result block with the page, or with errorCode when the fetch failed
+func webFetchBlocks(tool gjson.Result, i int, rawURL string, page *fetchedPage, errorCode string) []map[string]interface{} {
+	toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano()+int64(i))
+	name := tool.Get("name").String()
+	if name == "" {
+		name = "web_fetch"
+	}
+
+	var content map[string]interface{}
+	if page == nil {
+		content = map[string]interface{}{
+			"type":       "web_fetch_tool_result_error",
+			"error_code": errorCode,
+		}
+	} else {
+		document := map[string]interface{}{
+			"type": "document",
+			"source": map[string]interface{}{
+				"type":       "text",
+				"media_type": "text/plain",
+				"data":       page.Text,
+			},
+			"title": page.Title,
+		}
+		if citations := tool.Get("citations"); citations.IsObject() {
+			document["citations"] = citations.Value()
+		}
+		content = map[string]interface{}{
+			"type":         "web_fetch_result",
+			"url":          rawURL,
+			"content":      document,
+			"retrieved_at": time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	return []map[string]interface{}{
+		{
+			"type":  "server_tool_use",
+			"id":    toolUseID,
+			"name":  name,
+			"input": map[string]interface{}{"url": rawURL},
+		},
+		{
+			"type":        "web_fetch_tool_result",
+			"tool_use_id": toolUseID,
+			"content":     content,
+		},
+	}
+}
@@ -6,19 +6,33 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cliproxyapi/cpa_websearch_proxy/internal"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "auth" && os.Args[2] == "validate" {
+		runAuthValidate(os.Args[3:])
+		return
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to config file")
 	port := flag.Int("port", 0, "Listen port (overrides config)")
+	selfTest := flag.Bool("self-test", false, "Run a test search, report the result and exit")
 	showHelp := flag.Bool("help", false, "Show help message")
 	flag.Parse()
 
@@ -32,50 +46,100 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := internal.SetupLogging(cfg); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
 
 	// Override port if specified on command line
 	if *port != 0 {
 		cfg.ListenPort = *port
 	}
 
-	// Validate Gemini API key
-	if cfg.GeminiAPIKey == "" {
-		log.Fatal("GEMINI_API_KEY is required. Set it via environment variable or config file.")
+	// Without a Gemini API key, search with the fallback backends, or DuckDuckGo for basic
+	// web search results
+	if cfg.SearchBackend == internal.SearchBackendGemini && cfg.GeminiAuth == internal.GeminiAuthAPIKey && cfg.GeminiAPIKey == "" {
+		if len(cfg.SearchFallbackBackends) > 0 {
+			slog.Warn("No Gemini API key configured, searching with the fallback backends",
+				"backends", cfg.SearchFallbackBackends)
+			cfg.SearchBackend, cfg.SearchFallbackBackends = cfg.SearchFallbackBackends[0], cfg.SearchFallbackBackends[1:]
+		} else {
+			slog.Warn("No Gemini API key configured, using DuckDuckGo for basic web search results. " +
+				"Set GEMINI_API_KEY via environment variable, config file or GEMINI_API_KEY_FILE for Gemini search.")
+			cfg.SearchBackend = internal.SearchBackendDuckDuckGo
+		}
 	}
 
 	if cfg.UpstreamURL == "" {
-		log.Println("Warning: No upstream_url configured. Non-web_search requests will fail.")
-		log.Println("  Set UPSTREAM_URL env var or upstream_url in config.yaml")
+		slog.Warn("No upstream_url configured. Non-web_search requests will fail. Set UPSTREAM_URL env var or upstream_url in config.yaml")
 	}
 
 	// Create proxy server
 	proxy := internal.NewProxy(cfg)
 
-	// Print startup info
+	if *selfTest {
+		if err := proxy.SelfTest(context.Background()); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if cfg.StartupSelfTest {
+		// A failed check is reported but doesn't prevent startup
+		proxy.SelfTest(context.Background())
+	}
+
 	host := cfg.ListenHost
 	if host == "" {
 		host = internal.DefaultListenHost
+		cfg.ListenHost = host
+	}
+	scheme := "http"
+	if cfg.TLSCert != "" || len(cfg.TLSAutocertDomains) > 0 {
+		scheme = "https"
+	}
+
+	listeners, err := internal.OpenListeners(cfg)
+	if err != nil {
+		internal.Fatal("Failed to listen", "error", err)
 	}
-	addr := fmt.Sprintf("%s:%d", host, cfg.ListenPort)
+
+	// Print startup info
 	log.Println("========================================")
 	log.Println("  cpa_websearch_proxy for Claude Code")
 	log.Println("========================================")
-	log.Printf("Listen address: http://%s", addr)
-	if cfg.UpstreamURL != "" {
-		log.Printf("Upstream:       %s", cfg.UpstreamURL)
+	var baseURL string
+	for _, l := range listeners {
+		if l.Addr().Network() == "unix" {
+			log.Printf("Listen socket:  %s", l.Addr())
+			continue
+		}
+		log.Printf("Listen address: %s://%s", scheme, l.Addr())
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("%s://%s%s", scheme, l.Addr(), strings.TrimRight(cfg.BasePath, "/"))
+		}
+	}
+	if len(cfg.UpstreamURLs) > 0 {
+		log.Printf("Upstream:       %s", strings.Join(cfg.UpstreamURLs, ", "))
 	} else {
 		log.Println("Upstream:       (not configured)")
 	}
+	for _, route := range cfg.UpstreamRoutes {
+		log.Printf("Route:          %s -> %s", route.Model, strings.Join(route.Targets(), ", "))
+	}
 	log.Printf("Search model:   %s", cfg.WebSearchModel)
+	log.Printf("Search mode:    %s", cfg.WebSearchMode)
+	if cfg.ShadowMode {
+		log.Println("Shadow mode:    on (clients are served by the upstream)")
+	}
 	log.Printf("Log level:      %s", cfg.LogLevel)
-	log.Println("----------------------------------------")
-	log.Println("Configure Claude Code:")
-	log.Printf("  export ANTHROPIC_BASE_URL=http://%s", addr)
+	if baseURL != "" {
+		log.Println("----------------------------------------")
+		log.Println("Configure Claude Code:")
+		log.Printf("  export ANTHROPIC_BASE_URL=%s", baseURL)
+	}
 	log.Println("========================================")
 
 	// Start HTTP server
 	srv := &http.Server{
-		Addr:              addr,
 		Handler:           proxy,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       60 * time.Second,
@@ -83,22 +147,111 @@ func main() {
 		MaxHeaderBytes:    1 << 20, // 1MiB
 	}
 
-	// Set up graceful shutdown
+	// Set up graceful shutdown: stop accepting connections and let in-flight requests,
+	// including long SSE streams, finish within the drain period
+	drained := make(chan struct{})
 	go func() {
+		defer close(drained)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-sigCh
-		log.Printf("Received signal %v, shutting down...", sig)
+		internal.NotifySystemd("STOPPING=1")
+		drain := time.Duration(cfg.ShutdownDrainTimeout) * time.Second
+		slog.Info("Received signal, draining in-flight requests", "signal", sig.String(),
+			"in_flight", proxy.InFlight(), "web_searches", proxy.ActiveSearches(), "drain_timeout", drain)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Shutdown error: %v", err)
+			slog.Warn("Drain period expired, aborting in-flight requests",
+				"in_flight", proxy.InFlight(), "web_searches", proxy.ActiveSearches())
+			srv.Close()
+			return
 		}
+		slog.Info("All requests drained, exiting")
 	}()
 
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Server failed: %v", err)
+	// SIGHUP reloads credentials and the log level without dropping the listeners
+	go func() {
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		for range hupCh {
+			internal.NotifySystemd("RELOADING=1")
+			if err := proxy.Reload(); err != nil {
+				slog.Error("Reload failed, keeping the running configuration", "error", err)
+			}
+			internal.NotifySystemd("READY=1")
+		}
+	}()
+
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errCh <- srv.Serve(l)
+		}(l)
+	}
+	if err := internal.NotifySystemd("READY=1"); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+	for range listeners {
+		if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			internal.Fatal("Server failed", "error", err)
+		}
+	}
+	// Serve returns as soon as Shutdown starts; wait for the drain to complete
+	<-drained
+}
+
+// runReplay implements the replay subcommand, serving a captured SSE stream
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8319", "Address to serve the replay on")
+	delay := fs.Duration("delay", 50*time.Millisecond, "Delay between events")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: cpa_websearch_proxy replay [-listen addr] [-delay 50ms] <capture.sse>")
+		os.Exit(2)
+	}
+	if err := internal.ReplaySSE(*listen, fs.Arg(0), *delay); err != nil {
+		internal.Fatal("Replay failed", "error", err)
+	}
+}
+
+// runAuthValidate implements the auth validate subcommand, checking every Gemini key
+// and printing a table of the results
+func runAuthValidate(args []string) {
+	fs := flag.NewFlagSet("auth validate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to config file")
+	search := fs.Bool("search", false, "Run a tiny web search with each key")
+	fs.Parse(args)
+
+	cfg, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := internal.SetupLogging(cfg); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	checks, err := internal.ValidateCredentials(context.Background(), cfg, *search)
+	if err != nil {
+		internal.Fatal("Failed to set up Gemini authentication", "error", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS\tREASON")
+	failed := 0
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Key, check.Status, check.Reason)
+		if check.Status != internal.CredentialValid {
+			failed++
+		}
+	}
+	tw.Flush()
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d credentials failed validation\n", failed, len(checks))
+		os.Exit(1)
 	}
 }
 
@@ -108,19 +261,124 @@ func printUsage() {
 USAGE:
   cpa_websearch_proxy [OPTIONS]
 
+COMMANDS:
+  replay [-listen addr] [-delay 50ms] <capture.sse>
+                      Serve a captured SSE stream to POST /v1/messages
+  auth validate [-config path] [-search]
+                      Check every Gemini key (model lookup, or a tiny search with
+                      -search) and print which are valid, rejected or rate limited
+
 OPTIONS:
   -port <port>        Listen port (default: 8318)
   -config <path>      Path to config file (default: config.yaml)
+  -self-test          Run a test search, report the result and exit
   -help               Show this help message
 
 ENVIRONMENT VARIABLES:
-  GEMINI_API_KEY      Gemini API key (required)
+  GEMINI_API_KEY      Gemini API key, or comma-separated keys to rotate
+  GEMINI_API_KEYS     Comma-separated Gemini API keys rotated on auth/quota errors
   UPSTREAM_URL        Claude API proxy URL (default: http://localhost:8317)
+  UPSTREAM_URLS       Comma-separated upstream URLs for failover
   LISTEN_HOST         Listen host (default: 127.0.0.1)
   LISTEN_PORT         Listen port (default: 8318)
+  LISTEN_SOCKET       Unix domain socket path to listen on
+  LISTEN_ADDRESSES    Comma-separated host:port addresses to listen on
   WEB_SEARCH_MODEL    Gemini model for web search (default: gemini-2.5-flash)
+  SEARCH_DYNAMIC_THRESHOLD
+                      Only search when Gemini rates the need at least this high, 0 to 1 (default: 0, always)
   GEMINI_API_BASE_URL Gemini API base URL (defaults to UPSTREAM_URL)
   LOG_LEVEL           debug, info, warn, error (default: info)
+  LOG_FORMAT          text or json (default: text)
+  LOG_OUTPUT          stderr, syslog or journald (default: stderr)
+  ADMIN_TOKEN         Token for the /admin API (admin API disabled when unset)
+  GEMINI_RETRIES      Retries of Gemini network/5xx failures (default: 1)
+  GEMINI_AUTH         api_key, adc (Application Default Credentials) or vertex (default: api_key)
+  GEMINI_CREDENTIALS_FILE  Service account key or user credentials file for adc/vertex
+  GEMINI_CREDENTIALS_JSON  The same credentials inline, as JSON or base64
+  VERTEX_PROJECT      Google Cloud project for gemini_auth vertex
+  VERTEX_LOCATION     Vertex AI location (default: us-central1)
+  GEMINI_KEY_FAIL_COOLDOWN  Seconds a rejected API key sits out of the rotation (default: 300)
+  GEMINI_HEDGE_AFTER_MS  Repeat slow Gemini requests with another key after this long (default: 0, off)
+  GEMINI_KEY_MAX_CONCURRENT  Concurrent requests per Gemini key (default: 0, unlimited)
+  GEMINI_KEY_REQUESTS_PER_MINUTE  Requests per minute per Gemini key (default: 0, unlimited)
+  GEMINI_KEY_SELECTION  sticky or least_loaded (default: sticky)
+  GEMINI_KEY_DAILY_LIMIT  Daily request limit per Gemini key (default: 0, unlimited)
+  SEARCH_BACKEND      Search engine behind web searches: gemini, serper, exa, google_cse or
+                      duckduckgo (default: gemini, or duckduckgo without a Gemini API key)
+  SERPER_API_KEY      Serper.dev API key for SEARCH_BACKEND=serper
+  SERPER_BASE_URL     Serper API base URL (default: https://google.serper.dev)
+  EXA_API_KEY         Exa API key for SEARCH_BACKEND=exa
+  EXA_BASE_URL        Exa API base URL (default: https://api.exa.ai)
+  DUCKDUCKGO_BASE_URL DuckDuckGo base URL (default: https://html.duckduckgo.com)
+  GOOGLE_CSE_API_KEY, GOOGLE_CSE_CX
+                      Custom Search JSON API key and search engine ID for SEARCH_BACKEND=google_cse
+  SEARCH_FALLBACK_BACKENDS  Comma-separated backends tried in order when SEARCH_BACKEND fails
+  SEARCH_BACKEND_COOLDOWN  Seconds a failed backend is tried last (default: 60)
+  SEARCH_FANOUT_BACKENDS   Comma-separated backends queried along with every search, results merged
+  QUERY_REWRITE       Have Gemini write focused search queries before searching (default: false)
+  ENRICH_TOP_RESULTS  Fetch this many top result pages and pass their text on (default: 0, off)
+  ENRICH_MAX_CHARS    Characters of page text per result (default: 2000)
+  ENRICH_TIMEOUT_MS   Time allowed for fetching the pages (default: 5000)
+  SEARCH_MAX_RESULTS  Results requested from engines other than Gemini (default: 10)
+  WEB_FETCH_MAX_CHARS Characters of page text per web_fetch result (default: 100000)
+  FETCH_PRIVATE_ADDRESSES
+                      Let web_fetch and enrichment fetch internal hosts (default: false)
+  URL_CONTEXT         Let Gemini read the pages linked in the user message (default: true)
+  RESULT_DEDUPE       Drop results repeating the URL or title of another (default: true)
+  RESULT_RANKING      Result order: none, domain or recency (default: none)
+  PREFERRED_DOMAINS, DEMOTED_DOMAINS
+                      Comma-separated domains ranked first / last with RESULT_RANKING=domain
+  RESULT_MAX_COUNT    Most results returned per search (default: 0, all)
+  RESULT_BLOCKED_DOMAINS
+                      Comma-separated domains whose results are always dropped
+  GEMINI_API_KEY_FILE, PROXY_API_KEYS_FILE, ADMIN_TOKEN_FILE, ALERT_WEBHOOK_URL_FILE
+                      Read the secret from a file, e.g. a Docker secret
+  VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE  Vault used to resolve vault:// secret references
+  AUDIT_LOG           Append-only JSON Lines audit log of web searches
+  DEBUG_RECORD_DIR    Directory to record request/response pairs for debugging
+  ALERT_WEBHOOK_URL   Slack-compatible webhook for quota/auth alerts
+  ALERT_COOLDOWN      Seconds between alerts of the same kind (default: 900)
+  SSE_CAPTURE_DIR     Directory to capture emitted SSE streams for replay
+  STARTUP_SELF_TEST   Run a test search at startup (default: false)
+  USAGE_FILE          File persisting daily Gemini usage counters
+  DAILY_SEARCH_BUDGET Daily Gemini search limit (default: 0, unlimited)
+  DAILY_TOKEN_BUDGET  Daily Gemini token limit (default: 0, unlimited)
+  SLOW_REQUEST_THRESHOLD_MS  Log web searches slower than this at warn level (default: 0, off)
+  STATSD_ADDRESS      StatsD/DogStatsD agent host:port for metrics export
+  STATSD_PREFIX       Metric name prefix (default: cpa_websearch_proxy.)
+  STATSD_TAGS         Comma-separated DogStatsD tags, e.g. env:prod,team:ai
+  STATSD_FLUSH_INTERVAL  Counter flush interval in seconds (default: 10)
+  SSE_PING_INTERVAL   Seconds between SSE pings during search (default: 10)
+  WEB_SEARCH_FALLBACK Forward upstream without web_search on failure (default: false)
+  HYBRID_TOOLS        Pass client tools to Gemini alongside search (default: false)
+  WEB_SEARCH_MODE     replace or orchestrate (default: replace)
+  MAX_CONCURRENT_SEARCHES  Cap on in-flight web searches (default: 0 = unlimited)
+  INTERCEPT_MODE      always or tool_choice (default: always)
+  INTERCEPT_PERCENT   Share of web_search conversations sent to Gemini (default: 100)
+  SHADOW_MODE         Serve from upstream, run Gemini only for comparison (default: false)
+  SHADOW_LOG_DIR      Directory to record shadow mode responses
+  TLS_CERT, TLS_KEY   PEM certificate and key to serve HTTPS
+  TLS_CLIENT_CA       CA bundle required for client certificates (mTLS)
+  TLS_AUTOCERT_DOMAINS  Comma-separated domains to get Let's Encrypt certificates for
+  TLS_AUTOCERT_CACHE_DIR  Directory for the ACME account and certificates
+  TLS_AUTOCERT_EMAIL  Contact address given to Let's Encrypt
+  PROXY_API_KEYS      Comma-separated API keys clients must present
+  ALLOWED_CIDRS       Comma-separated source IPs/CIDRs allowed to connect
+  CORS_ALLOWED_ORIGINS Comma-separated browser origins allowed via CORS
+  CORS_EXPOSE_HEADERS Comma-separated response headers exposed to browsers
+  UPSTREAM_RETRIES    Retries for refused, or idempotent 502/503, upstream requests (default: 2)
+  UPSTREAM_RETRY_BACKOFF_MS  Initial upstream retry delay (default: 250)
+  OUTBOUND_PROXY      Proxy URL (http/https/socks5) for requests to Google
+  CA_BUNDLE           Extra CA bundle trusted for requests to Google
+  TLS_INSECURE_SKIP_VERIFY  Skip TLS verification for requests to Google
+  MAX_IDLE_CONNS_PER_HOST   Idle connections kept per host (default: 32)
+  IDLE_CONN_TIMEOUT   Seconds before idle connections close (default: 90)
+  KEEP_ALIVE          TCP keep-alive period in seconds (default: 30)
+  DISABLE_HTTP2       Disable HTTP/2 for outgoing connections (default: false)
+  COMPRESS_MIN_BYTES  Gzip JSON responses from this size (default: 4096, 0 = off)
+  SHUTDOWN_DRAIN_TIMEOUT  Seconds to let requests finish on shutdown (default: 60)
+  LISTEN_REUSE_PORT   Bind with SO_REUSEPORT for zero-downtime upgrades
+  BASE_PATH           Path prefix when served behind a reverse proxy
 
 EXAMPLE:
   export GEMINI_API_KEY="AIza..."